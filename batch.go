@@ -0,0 +1,116 @@
+package stomp
+
+import "time"
+
+// Batches groups the messages of s into batches of up to size messages for
+// bulk processing. A batch is delivered when it is full or maxWait after its
+// first message was received. The returned channel is closed after the last
+// batch when s is closed. Batches reads from s.C, which must not be read
+// elsewhere.
+func (s *Subscription) Batches(size int, maxWait time.Duration) <-chan []*Message {
+	if size <= 0 {
+		size = 1
+	}
+
+	out := make(chan []*Message)
+	go func() {
+		defer close(out)
+
+		var (
+			batch  []*Message
+			expire <-chan time.Time
+			timer  *time.Timer
+		)
+		flush := func() {
+			if timer != nil {
+				timer.Stop()
+			}
+			out <- batch
+			batch, expire, timer = nil, nil, nil
+		}
+
+		for {
+			select {
+			case msg, ok := <-s.C:
+				if !ok {
+					if len(batch) > 0 {
+						flush()
+					}
+					return
+				}
+
+				batch = append(batch, msg)
+				if len(batch) == 1 && size > 1 {
+					timer = time.NewTimer(maxWait)
+					expire = timer.C
+				}
+				if len(batch) >= size {
+					flush()
+				}
+
+			case <-expire:
+				flush()
+			}
+		}
+	}()
+	return out
+}
+
+// AckBatch acknowledges a batch of messages received from one subscription.
+// In the client ack mode, a single cumulative ACK frame for the last message
+// is sent, otherwise each message is acknowledged on its own.
+func (c *Conn) AckBatch(msgs []*Message, options ...Option) error {
+	return c.acknowledgeBatch("ACK", batchMode(msgs), msgs, options)
+}
+
+// NackBatch is the opposite of AckBatch.
+func (c *Conn) NackBatch(msgs []*Message, options ...Option) error {
+	return c.acknowledgeBatch("NACK", batchMode(msgs), msgs, options)
+}
+
+// batchMode returns the ack mode of the subscription msgs were received
+// from.
+func batchMode(msgs []*Message) AckMode {
+	if len(msgs) == 0 {
+		return ""
+	}
+	return msgs[len(msgs)-1].mode
+}
+
+func (c *Conn) acknowledgeBatch(command string, mode AckMode, msgs []*Message, options []Option) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	if mode == AckClient {
+		return c.acknowledge(command, msgs[len(msgs)-1], options)
+	}
+
+	for _, msg := range msgs {
+		if err := c.acknowledge(command, msg, options); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandleBatches processes the messages of sub in batches, see Batches, until
+// sub is closed. A batch is acknowledged with AckBatch if handler returns
+// nil, otherwise the whole batch is NACKed.
+func (c *Conn) HandleBatches(sub *Subscription, size int, maxWait time.Duration, handler func(msgs []*Message) error) {
+	mode := sub.ack
+	for batch := range sub.Batches(size, maxWait) {
+		if err := handler(batch); err != nil {
+			c.logger.Warn("stomp: batch processing failed", "messages", len(batch), "err", err)
+			err = c.acknowledgeBatch("NACK", mode, batch, nil)
+			if err != nil {
+				c.logger.Warn("stomp: failed to nack batch", "messages", len(batch), "err", err)
+			}
+			continue
+		}
+
+		if err := c.acknowledgeBatch("ACK", mode, batch, nil); err != nil {
+			c.logger.Warn("stomp: failed to ack batch", "messages", len(batch), "err", err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package stomp_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"github.com/cumulodev/stomp/stomptest"
+)
+
+func TestBatches(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.Subscribe("/queue/test", stomp.Ack(stomp.AckClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		s.Publish("/queue/test", []byte(strconv.Itoa(i)), nil)
+	}
+
+	// full batches are delivered at once, the rest after maxWait
+	batches := sub.Batches(2, 50*time.Millisecond)
+	for _, want := range []int{2, 2, 1} {
+		select {
+		case batch := <-batches:
+			if len(batch) != want {
+				t.Errorf("got batch of %d messages, want %d", len(batch), want)
+			}
+			if err := conn.AckBatch(batch); err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for batch")
+		}
+	}
+
+	// one cumulative ACK per batch
+	frames, err := s.WaitFrames("ACK", 3, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 3 {
+		t.Errorf("got %d ACK frames, want 3", len(frames))
+	}
+}
+
+func TestHandleBatches(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := conn.Subscribe("/queue/test", stomp.Ack(stomp.AckIndividual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		s.Publish("/queue/test", []byte(strconv.Itoa(i)), nil)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		failed := false
+		conn.HandleBatches(sub, 3, time.Second, func(msgs []*stomp.Message) error {
+			if !failed {
+				failed = true
+				return errors.New("insert failed")
+			}
+			return nil
+		})
+	}()
+
+	// the failed batch is NACKed as a whole and redelivered
+	if _, err := s.WaitFrames("NACK", 3, time.Second); err != nil {
+		t.Error(err)
+	}
+	if _, err := s.WaitFrames("ACK", 3, time.Second); err != nil {
+		t.Error(err)
+	}
+
+	conn.Close()
+	<-done
+}
+
+func TestHandleBatchesFullBuffer(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// more messages than the subscription buffers block the connection
+	// while the batches are processed
+	for i := range 100 {
+		s.Publish("/queue/test", []byte(strconv.Itoa(i)), nil)
+	}
+	sub, err := conn.Subscribe("/queue/test", stomp.Ack(stomp.AckClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go conn.HandleBatches(sub, 5, time.Second, func(msgs []*stomp.Message) error {
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	})
+
+	if _, err := s.WaitFrames("ACK", 20, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
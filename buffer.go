@@ -0,0 +1,187 @@
+package stomp
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// An OverflowPolicy determines what happens to a message sent while
+// reconnecting if the reconnect buffer is full, see Dialer.ReconnectBuffer.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks the sender until there is space in the buffer,
+	// the connection is re-established or the TTL of the message expires.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowFail fails the new message with ErrBufferFull.
+	OverflowFail
+
+	// OverflowDropOldest fails the oldest buffered message with
+	// ErrBufferFull to make space for the new one.
+	OverflowDropOldest
+)
+
+var (
+	// ErrBufferFull is returned for messages that do not fit into the
+	// reconnect buffer.
+	ErrBufferFull = errors.New("stomp: reconnect buffer full")
+
+	// ErrBufferExpired is returned for messages whose TTL expired before
+	// the connection was re-established.
+	ErrBufferExpired = errors.New("stomp: message expired in reconnect buffer")
+)
+
+// sendBuffer holds the SEND frames sent while reconnecting until they are
+// written by the next write loop.
+type sendBuffer struct {
+	size   int
+	policy OverflowPolicy
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	frames  []frame
+	changed chan struct{}
+}
+
+func newSendBuffer(size int, policy OverflowPolicy, ttl time.Duration) *sendBuffer {
+	if size <= 0 {
+		return nil
+	}
+	return &sendBuffer{size: size, policy: policy, ttl: ttl, changed: make(chan struct{})}
+}
+
+// push appends f to the buffer. If the buffer is full and f has to wait,
+// push returns a channel closed when space may be available.
+func (b *sendBuffer) push(f frame) (<-chan struct{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.frames) >= b.size {
+		switch b.policy {
+		case OverflowFail:
+			return nil, ErrBufferFull
+		case OverflowDropOldest:
+			b.frames[0].ch <- ErrBufferFull
+			b.frames = b.frames[1:]
+		default:
+			return b.changed, nil
+		}
+	}
+
+	b.frames = append(b.frames, f)
+	return nil, nil
+}
+
+// pop removes the oldest frame from the buffer.
+func (b *sendBuffer) pop() (frame, bool) {
+	if b == nil {
+		return frame{}, false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.frames) == 0 {
+		return frame{}, false
+	}
+
+	f := b.frames[0]
+	b.frames = b.frames[1:]
+	b.notify()
+	return f, true
+}
+
+// remove removes the frame with the result channel ch and reports whether
+// it was still buffered.
+func (b *sendBuffer) remove(ch chan error) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, f := range b.frames {
+		if f.ch == ch {
+			b.frames = append(b.frames[:i:i], b.frames[i+1:]...)
+			b.notify()
+			return true
+		}
+	}
+	return false
+}
+
+// fail fails all buffered frames with err.
+func (b *sendBuffer) fail(err error) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, f := range b.frames {
+		f.ch <- err
+	}
+	b.frames = nil
+	b.notify()
+}
+
+// notify wakes up senders waiting for space. The caller must hold b.mu.
+func (b *sendBuffer) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// bufferWrite buffers f while the connection is re-established. It reports
+// false if f is not to be buffered.
+func (c *Conn) bufferWrite(f frame) (bool, error) {
+	// the TTL timer is only started once the frame is buffered
+	var timer *time.Timer
+	expire := func() <-chan time.Time {
+		if c.buffer.ttl <= 0 {
+			return nil
+		}
+		if timer == nil {
+			timer = time.NewTimer(c.buffer.ttl)
+		}
+		return timer.C
+	}
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		// the state is checked and the frame buffered under c.mu, so
+		// it is either buffered before the next write loop starts or
+		// written by it
+		c.mu.Lock()
+		if !c.reconnecting || c.closed {
+			c.mu.Unlock()
+			return false, nil
+		}
+		wait, err := c.buffer.push(f)
+		c.mu.Unlock()
+
+		if err != nil {
+			return true, err
+		}
+		if wait == nil {
+			break
+		}
+
+		select {
+		case <-wait:
+		case <-expire():
+			return true, ErrBufferExpired
+		}
+	}
+
+	select {
+	case err := <-f.ch:
+		return true, err
+	case <-expire():
+		if c.buffer.remove(f.ch) {
+			return true, ErrBufferExpired
+		}
+		// the frame is being written
+		return true, <-f.ch
+	}
+}
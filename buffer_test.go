@@ -0,0 +1,167 @@
+package stomp_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"github.com/cumulodev/stomp/stomptest"
+)
+
+func TestReconnectBuffer(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	r := newReconnects(10)
+	d := r.dialer(nil)
+	d.Reconnect = func(n int, _ time.Duration, err error) (bool, time.Duration) {
+		r.errs <- err
+		return true, 300 * time.Millisecond
+	}
+	d.ReconnectBuffer = 2
+	d.ReconnectOverflow = stomp.OverflowDropOldest
+
+	conn, err := d.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s.DropConnections()
+	r.err(t)
+
+	// messages are buffered in order, the oldest is dropped on overflow
+	results := make(map[string]chan error)
+	for _, body := range []string{"one", "two", "three"} {
+		ch := make(chan error, 1)
+		results[body] = ch
+		go func() {
+			ch <- conn.Send("/queue/test", "text/plain", []byte(body))
+		}()
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// other frames are not buffered
+	if err := conn.Ack(&stomp.Message{Frame: stomp.Frame{Header: stomp.Header{"ack": "1"}}}); err == nil {
+		t.Error("got no error for ACK while reconnecting")
+	}
+
+	if err := <-results["one"]; !errors.Is(err, stomp.ErrBufferFull) {
+		t.Errorf("got error %v for oldest message, want ErrBufferFull", err)
+	}
+	for _, body := range []string{"two", "three"} {
+		if err := <-results[body]; err != nil {
+			t.Errorf("got error %v for %q, want nil", err, body)
+		}
+	}
+	r.wait(t)
+
+	sub, err := conn.Subscribe("/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"two", "three"} {
+		if msg := receive(t, sub); string(msg.Body) != want {
+			t.Errorf("got message %q, want %q", msg.Body, want)
+		}
+	}
+}
+
+func TestReconnectBufferOverflow(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	r := newReconnects(10)
+	d := r.dialer(nil)
+	d.Reconnect = func(n int, _ time.Duration, err error) (bool, time.Duration) {
+		r.errs <- err
+		return true, time.Second
+	}
+	d.ReconnectBuffer = 1
+	d.ReconnectOverflow = stomp.OverflowFail
+	d.ReconnectTTL = 100 * time.Millisecond
+
+	conn, err := d.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s.DropConnections()
+	r.err(t)
+
+	expired := make(chan error, 1)
+	go func() {
+		expired <- conn.Send("/queue/test", "text/plain", []byte("one"))
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	if err := conn.Send("/queue/test", "text/plain", []byte("two")); !errors.Is(err, stomp.ErrBufferFull) {
+		t.Errorf("got error %v for full buffer, want ErrBufferFull", err)
+	}
+	if err := <-expired; !errors.Is(err, stomp.ErrBufferExpired) {
+		t.Errorf("got error %v after TTL, want ErrBufferExpired", err)
+	}
+
+	// the buffered message is dropped on close
+	closed := make(chan error, 1)
+	go func() {
+		closed <- conn.Send("/queue/test", "text/plain", []byte("three"))
+	}()
+	time.Sleep(20 * time.Millisecond)
+	conn.Close()
+	if err := <-closed; err == nil {
+		t.Error("got no error for message buffered at close")
+	}
+}
+
+func TestReconnectBufferPending(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	dialer := stomptest.NewFaultDialer()
+	r := newReconnects(10)
+	d := r.dialer(dialer.Dial)
+	d.ReconnectBuffer = 10
+
+	conn, err := d.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the first message blocks the write loop, the others wait for it
+	dialer.Conns()[0].Inject(stomptest.StallAfter(stomptest.Write, 0))
+	results := make(chan error, 3)
+	for _, body := range []string{"one", "two", "three"} {
+		go func() {
+			results <- conn.Send("/queue/test", "text/plain", []byte(body))
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// messages waiting for the write loop are buffered when the
+	// connection is reset
+	s.DropConnections()
+	r.err(t)
+	r.wait(t)
+
+	var failed int
+	for range 3 {
+		if err := <-results; err != nil {
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Errorf("got %d failed sends, want only the stalled one", failed)
+	}
+
+	sub, err := conn.Subscribe("/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		receive(t, sub)
+	}
+}
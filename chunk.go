@@ -0,0 +1,365 @@
+package stomp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultChunkSize is the chunk size used by SendLarge if none is given.
+	DefaultChunkSize = 1 << 20
+
+	// DefaultReassemblyMemory is the number of octets a Reassembler buffers
+	// in memory unless configured otherwise.
+	DefaultReassemblyMemory = 64 << 20
+
+	// DefaultReassemblyTimeout is how long a Reassembler waits for the
+	// chunks of a message unless configured otherwise.
+	DefaultReassemblyTimeout = 5 * time.Minute
+)
+
+// Header names describing the chunks of a message sent with SendLarge.
+const (
+	ChunkGroupHeader    = "chunk-group"
+	ChunkIndexHeader    = "chunk-index"
+	ChunkCountHeader    = "chunk-count"
+	ChunkChecksumHeader = "chunk-checksum"
+)
+
+// SendLarge sends a message like Send, but splits the body into chunks of at
+// most chunkSize octets that are sent as separate SEND frames. If chunkSize
+// is not positive, DefaultChunkSize is used. The options are applied to
+// every chunk.
+//
+// Each chunk carries the ID of the message, its index, the total number of
+// chunks and the SHA-256 checksum of the complete body in headers. Use a
+// Reassembler to receive the message.
+func (c *Conn) SendLarge(destination, contentType string, body []byte, chunkSize int, options ...Option) error {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	sum := sha256.Sum256(body)
+	checksum := hex.EncodeToString(sum[:])
+	group := randID()
+	count := max(1, (len(body)+chunkSize-1)/chunkSize)
+
+	for i := 0; i < count; i++ {
+		chunk := body[i*chunkSize : min((i+1)*chunkSize, len(body))]
+		err := c.Send(destination, contentType, chunk, append(options[:len(options):len(options)], func(f *Frame) {
+			f.Header[ChunkGroupHeader] = group
+			f.Header[ChunkIndexHeader] = strconv.Itoa(i)
+			f.Header[ChunkCountHeader] = strconv.Itoa(count)
+			f.Header[ChunkChecksumHeader] = checksum
+		})...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A Reassembler reassembles messages sent with SendLarge from their chunks.
+//
+// The reassembled message has the headers of the first chunk and is
+// acknowledged with Conn.Ack or Conn.Nack like any other message, which
+// acknowledges all of its chunks. Chunks are only acknowledged by the
+// Reassembler if the message is rejected because it is incomplete or
+// corrupt. As chunks of different messages may interleave, subscriptions
+// should use the client-individual ack mode.
+type Reassembler struct {
+	// MaxMemory limits the octets of incomplete messages buffered in
+	// memory. If zero, DefaultReassemblyMemory is used.
+	MaxMemory int64
+
+	// SpillDir, if not empty, is the directory chunks exceeding MaxMemory
+	// are written to until their message is complete. Otherwise messages
+	// exceeding MaxMemory are rejected.
+	SpillDir string
+
+	// Timeout is how long to wait for the remaining chunks of a message
+	// after its first chunk arrived before rejecting it. If zero,
+	// DefaultReassemblyTimeout is used.
+	Timeout time.Duration
+}
+
+// SubscribeLarge subscribes to a destination like Subscribe and reassembles
+// messages sent with SendLarge using the default Reassembler.
+func (c *Conn) SubscribeLarge(destination string, options ...Option) (*Subscription, error) {
+	return Reassembler{}.Subscribe(c, destination, options...)
+}
+
+// Subscribe subscribes to a destination like Conn.Subscribe. Messages sent
+// with SendLarge are delivered once all of their chunks were received,
+// other messages are delivered unchanged.
+func (r Reassembler) Subscribe(c *Conn, destination string, options ...Option) (*Subscription, error) {
+	sub, err := c.Subscribe(destination, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.MaxMemory == 0 {
+		r.MaxMemory = DefaultReassemblyMemory
+	}
+	if r.Timeout <= 0 {
+		r.Timeout = DefaultReassemblyTimeout
+	}
+
+	out := sub.derive()
+
+	ra := &reassembly{Reassembler: r, conn: c, ack: sub.ack, groups: make(map[string]*chunkGroup)}
+	go ra.run(sub.C, out.C, sub.state.done)
+	return out, nil
+}
+
+// reassembly is the state of a Reassembler for one subscription.
+type reassembly struct {
+	Reassembler
+	conn   *Conn
+	ack    AckMode
+	groups map[string]*chunkGroup
+	memory int64
+}
+
+// chunkGroup collects the chunks of one message. The chunks are kept in a
+// map, as the chunk count of the first chunk received cannot be trusted
+// to allocate them upfront.
+type chunkGroup struct {
+	id       string
+	checksum string
+	header   Header
+	acks     []string
+	count    int
+	chunks   map[int]chunk
+	started  time.Time
+	memory   int64
+	file     *os.File
+	spilled  int64
+}
+
+// chunk is a received chunk, kept in memory or spilled to the group file.
+type chunk struct {
+	data    []byte
+	spilled bool
+	offset  int64
+	size    int64
+}
+
+func (r *reassembly) run(in <-chan *Message, out chan<- *Message, done <-chan struct{}) {
+	defer close(out)
+	defer func() {
+		// the connection is closed, the chunks will be redelivered
+		for _, g := range r.groups {
+			r.release(g)
+		}
+	}()
+
+	ticker := time.NewTicker(r.Timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg, ok := <-in:
+			if !ok {
+				return
+			}
+			if msg = r.add(msg); msg == nil {
+				continue
+			}
+			select {
+			case out <- msg:
+			case <-done:
+				// nobody is receiving from an ended subscription
+				return
+			}
+
+		case <-ticker.C:
+			for _, g := range r.groups {
+				if time.Since(g.started) > r.Timeout {
+					r.reject(g, errors.New("timeout waiting for chunks"))
+				}
+			}
+		}
+	}
+}
+
+// add adds a received message and returns the message to deliver, if any.
+func (r *reassembly) add(msg *Message) *Message {
+	id := msg.Header[ChunkGroupHeader]
+	if id == "" {
+		return msg
+	}
+
+	// a streamed body must be consumed before the connection reads the
+	// next frame, even if the chunk is dropped
+	body, size := msg.BodyReader(), int64(len(msg.Body))
+	if msg.stream != nil {
+		size = msg.stream.remaining
+	}
+	defer body.Close()
+
+	g, ok := r.groups[id]
+	if !ok {
+		g = &chunkGroup{
+			id:       id,
+			checksum: msg.Header[ChunkChecksumHeader],
+			started:  time.Now(),
+		}
+		count, err := strconv.Atoi(msg.Header[ChunkCountHeader])
+		if err != nil || count < 1 {
+			g.acks = appendAck(g.acks, msg)
+			r.reject(g, fmt.Errorf("invalid chunk count %q", msg.Header[ChunkCountHeader]))
+			return nil
+		}
+		g.count = count
+		g.chunks = make(map[int]chunk)
+		r.groups[id] = g
+	}
+	g.acks = appendAck(g.acks, msg)
+
+	index, err := strconv.Atoi(msg.Header[ChunkIndexHeader])
+	if err != nil || index < 0 || index >= g.count || msg.Header[ChunkCountHeader] != strconv.Itoa(g.count) {
+		r.reject(g, fmt.Errorf("invalid chunk %q of %q", msg.Header[ChunkIndexHeader], msg.Header[ChunkCountHeader]))
+		return nil
+	}
+
+	if _, ok := g.chunks[index]; ok {
+		// redelivered chunk, acknowledged with the message
+		return nil
+	}
+	if index == 0 {
+		g.header = msg.Header
+	}
+
+	if err := r.store(g, index, body, size); err != nil {
+		r.reject(g, err)
+		return nil
+	}
+
+	if len(g.chunks) < g.count {
+		return nil
+	}
+	return r.assemble(g)
+}
+
+// store buffers the body of a chunk of size octets in memory or spills it
+// to disk.
+func (r *reassembly) store(g *chunkGroup, index int, body io.Reader, size int64) error {
+	if r.memory+size <= r.MaxMemory {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		size = int64(len(data))
+		g.chunks[index] = chunk{data: data, size: size}
+		g.memory += size
+		r.memory += size
+		return nil
+	}
+
+	if r.SpillDir == "" {
+		return errors.New("memory limit exceeded")
+	}
+
+	if g.file == nil {
+		f, err := os.CreateTemp(r.SpillDir, "stomp-chunks-*")
+		if err != nil {
+			return err
+		}
+		g.file = f
+	}
+
+	n, err := io.Copy(g.file, body)
+	if err != nil {
+		return err
+	}
+	g.chunks[index] = chunk{spilled: true, offset: g.spilled, size: n}
+	g.spilled += n
+	return nil
+}
+
+// assemble joins the chunks of a complete group into a message.
+func (r *reassembly) assemble(g *chunkGroup) *Message {
+	defer r.release(g)
+
+	var size int64
+	for _, ch := range g.chunks {
+		size += ch.size
+	}
+
+	body := make([]byte, 0, size)
+	for i := range g.count {
+		ch := g.chunks[i]
+		if !ch.spilled {
+			body = append(body, ch.data...)
+			continue
+		}
+
+		data := make([]byte, ch.size)
+		if _, err := g.file.ReadAt(data, ch.offset); err != nil {
+			r.reject(g, err)
+			return nil
+		}
+		body = append(body, data...)
+	}
+
+	sum := sha256.Sum256(body)
+	if hex.EncodeToString(sum[:]) != g.checksum {
+		r.reject(g, errors.New("checksum mismatch"))
+		return nil
+	}
+
+	header := maps.Clone(g.header)
+	delete(header, ChunkGroupHeader)
+	delete(header, ChunkIndexHeader)
+	delete(header, ChunkCountHeader)
+	delete(header, ChunkChecksumHeader)
+	header["content-length"] = strconv.Itoa(len(body))
+
+	return &Message{
+		Frame: Frame{Command: "MESSAGE", Header: header, Body: body},
+		acks:  g.acks,
+		conn:  r.conn,
+		mode:  r.ack,
+	}
+}
+
+// reject drops a group and returns its chunks to the server.
+func (r *reassembly) reject(g *chunkGroup, err error) {
+	r.release(g)
+	r.conn.logger.Warn("stomp: rejecting chunked message",
+		"group", g.id, "chunks", len(g.acks), "error", err)
+
+	if len(g.acks) > 0 {
+		if err := r.conn.Nack(&Message{acks: g.acks}); err != nil {
+			r.conn.logger.Warn("stomp: failed to nack chunks", "group", g.id, "error", err)
+		}
+	}
+}
+
+// release frees the resources of a group.
+func (r *reassembly) release(g *chunkGroup) {
+	delete(r.groups, g.id)
+	r.memory -= g.memory
+	g.memory = 0
+	if g.file != nil {
+		g.file.Close()
+		os.Remove(g.file.Name())
+		g.file = nil
+	}
+}
+
+// appendAck appends the ack ID of msg to acks if it has one.
+func appendAck(acks []string, msg *Message) []string {
+	if id := msg.AckID(); id != "" {
+		return append(acks, id)
+	}
+	return acks
+}
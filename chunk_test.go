@@ -0,0 +1,195 @@
+package stomp_test
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"github.com/cumulodev/stomp/stomptest"
+)
+
+func TestSendLarge(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	dir := t.TempDir()
+	r := stomp.Reassembler{MaxMemory: 3000, SpillDir: dir}
+	sub, err := r.Subscribe(conn, "/queue/test", stomp.Ack(stomp.AckIndividual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := make([]byte, 10000)
+	rand.New(rand.NewSource(1)).Read(large)
+	if err := conn.SendLarge("/queue/test", "application/octet-stream", large, 1000, stomp.Persist()); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Send("/queue/test", "text/plain", []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+
+	frames, err := s.WaitFrames("SEND", 11, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range frames[:10] {
+		if len(f.Body) != 1000 || f.Header[stomp.ChunkCountHeader] != "10" || f.Header["persistent"] != "true" {
+			t.Errorf("got chunk %d %v with %d octets, want 1000 octets of 10 chunks", i, f.Header, len(f.Body))
+		}
+	}
+
+	msg := receive(t, sub)
+	if !bytes.Equal(msg.Body, large) || msg.ContentLength() != len(large) || msg.Header[stomp.ChunkGroupHeader] != "" {
+		t.Errorf("got message %v with %d octets, want reassembled body", msg.Header, len(msg.Body))
+	}
+	if msg := receive(t, sub); string(msg.Body) != "small" {
+		t.Errorf("got message %q, want %q", msg.Body, "small")
+	}
+
+	// chunks are only acknowledged with the reassembled message
+	if acks := commands(s.Frames(), "ACK"); acks != 0 {
+		t.Errorf("got %d ACK frames before Ack, want none", acks)
+	}
+	if err := conn.Ack(msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WaitFrames("ACK", 10, time.Second); err != nil {
+		t.Error(err)
+	}
+
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("got %d spill files, want none", len(files))
+	}
+}
+
+func TestSendLargeCorrupt(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r := stomp.Reassembler{MaxMemory: 100, Timeout: 100 * time.Millisecond}
+	sub, err := r.Subscribe(conn, "/queue/test", stomp.Ack(stomp.AckIndividual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunk := func(group, index, checksum string, body string) {
+		s.Publish("/queue/test", []byte(body), stomp.Header{
+			stomp.ChunkGroupHeader:    group,
+			stomp.ChunkIndexHeader:    index,
+			stomp.ChunkCountHeader:    "2",
+			stomp.ChunkChecksumHeader: checksum,
+		})
+	}
+
+	chunk("corrupt", "0", "0000", "hello")
+	chunk("corrupt", "1", "0000", "world")
+	chunk("incomplete", "0", "0000", "hello")
+	chunk("large", "0", "0000", string(make([]byte, 101)))
+
+	// the chunk count is not used to allocate the chunks upfront
+	s.Publish("/queue/test", []byte("hello"), stomp.Header{
+		stomp.ChunkGroupHeader:    "huge",
+		stomp.ChunkIndexHeader:    "0",
+		stomp.ChunkCountHeader:    "1000000000000",
+		stomp.ChunkChecksumHeader: "0000",
+	})
+
+	if _, err := s.WaitFrames("NACK", 5, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-sub.C:
+		t.Errorf("got message %v, want rejected chunks", msg.Header)
+	default:
+	}
+}
+
+func TestSendLargeClientAck(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.SubscribeLarge("/queue/test", stomp.Ack(stomp.AckClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"hello world", "goodbye world"} {
+		if err := conn.SendLarge("/queue/test", "text/plain", []byte(body), 8); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msgs := []*stomp.Message{receive(t, sub), receive(t, sub)}
+
+	// the reassembled messages are acknowledged cumulatively with the
+	// chunks of the last one
+	if err := conn.AckBatch(msgs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WaitFrames("ACK", 2, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := commands(s.Frames(), "ACK"); n != 2 {
+		t.Errorf("got %d ACK frames, want 2", n)
+	}
+}
+
+// commands returns the number of frames with the given command.
+func commands(frames []*stomp.Frame, command string) int {
+	n := 0
+	for _, f := range frames {
+		if f.Command == command {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSendLargeStreamed(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	// chunks are streamed, they must be read by the reassembler
+	conn, err := (&stomp.Dialer{StreamThreshold: 500}).Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r := stomp.Reassembler{MaxMemory: 3000, SpillDir: t.TempDir()}
+	sub, err := r.Subscribe(conn, "/queue/test", stomp.Ack(stomp.AckIndividual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := make([]byte, 10000)
+	rand.New(rand.NewSource(1)).Read(large)
+	if err := conn.SendLarge("/queue/test", "application/octet-stream", large, 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg := receive(t, sub); !bytes.Equal(msg.Body, large) {
+		t.Errorf("got message %v with %d octets, want reassembled body", msg.Header, len(msg.Body))
+	}
+}
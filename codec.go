@@ -0,0 +1,160 @@
+package stomp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"sync"
+)
+
+// DefaultContentType is the content type used by SendValue unless changed
+// by the ContentType option.
+const DefaultContentType = "application/json"
+
+// A Codec converts between values and message bodies of a content type.
+type Codec interface {
+	// ContentType returns the MIME type of the bodies produced by Marshal.
+	ContentType() string
+
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var codecs = struct {
+	sync.RWMutex
+	m map[string]Codec
+}{m: make(map[string]Codec)}
+
+func init() {
+	RegisterCodec(JSONCodec{})
+	RegisterCodec(RawCodec("application/octet-stream"))
+	RegisterCodec(RawCodec("text/plain"))
+}
+
+// RegisterCodec makes a codec available for its content type, replacing any
+// codec registered before for the same type. Packages providing codecs
+// usually register them in their init function.
+func RegisterCodec(c Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.m[mediaType(c.ContentType())] = c
+}
+
+// CodecFor returns the codec registered for a content type. Parameters of
+// the content type, such as the charset, are ignored.
+func CodecFor(contentType string) (Codec, bool) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	c, ok := codecs.m[mediaType(contentType)]
+	return c, ok
+}
+
+func mediaType(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return t
+}
+
+// ContentType sets the content type of a frame. SendValue uses it to select
+// the codec for the message body.
+func ContentType(contentType string) Option {
+	return func(f *Frame) {
+		f.Header["content-type"] = contentType
+	}
+}
+
+// SendValue sends v encoded as message body to a destination. The codec is
+// selected by the content type, which is DefaultContentType unless changed
+// by the ContentType option. See Send for the semantics of destinations.
+func (c *Conn) SendValue(destination string, v any, options ...Option) error {
+	frame := &Frame{
+		Command: "SEND",
+		Header: Header{
+			"destination":  destination,
+			"content-type": DefaultContentType,
+		},
+	}
+
+	// the options are applied to a frame without body to find the codec,
+	// Send applies them again once the body is set, e.g. to compress it
+	for _, fn := range options {
+		fn(frame)
+	}
+
+	contentType := frame.Header["content-type"]
+	codec, ok := CodecFor(contentType)
+	if !ok {
+		return fmt.Errorf("stomp: no codec for content type %q", contentType)
+	}
+
+	body, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Send(destination, contentType, body, options...)
+}
+
+// Decode decodes the body of the message into v using the codec registered
+// for its content type. A message without content type is treated as binary
+// and decoded with the "application/octet-stream" codec. The body of a
+// streamed message is read to the end.
+func (m *Message) Decode(v any) error {
+	contentType := m.ContentType()
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	codec, ok := CodecFor(contentType)
+	if !ok {
+		return fmt.Errorf("stomp: no codec for content type %q", contentType)
+	}
+
+	body := m.Body
+	if m.stream != nil {
+		var err error
+		if body, err = io.ReadAll(m.stream); err != nil {
+			return err
+		}
+	}
+	return codec.Unmarshal(body, v)
+}
+
+// JSONCodec encodes values as JSON using the encoding/json package.
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string                { return "application/json" }
+func (JSONCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// RawCodec passes bodies through unchanged for its content type. It
+// marshals values of type []byte and string and unmarshals into values of
+// type *[]byte and *string.
+type RawCodec string
+
+func (c RawCodec) ContentType() string { return string(c) }
+
+func (c RawCodec) Marshal(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, fmt.Errorf("stomp: cannot marshal %T as %s", v, c)
+}
+
+func (c RawCodec) Unmarshal(data []byte, v any) error {
+	switch v := v.(type) {
+	case *[]byte:
+		*v = append((*v)[:0], data...)
+		return nil
+	case *string:
+		*v = string(data)
+		return nil
+	}
+	return fmt.Errorf("stomp: cannot unmarshal %s into %T", c, v)
+}
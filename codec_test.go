@@ -0,0 +1,107 @@
+package stomp_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"github.com/cumulodev/stomp/stomptest"
+)
+
+func TestSendValue(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.Subscribe("/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type order struct {
+		ID    int    `json:"id"`
+		Title string `json:"title"`
+	}
+
+	if err := conn.SendValue("/queue/test", order{1, "book"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SendValue("/queue/test", "plain", stomp.ContentType("text/plain; charset=utf-8")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SendValue("/queue/test", 1, stomp.ContentType("application/x-unknown")); err == nil {
+		t.Error("sent value without codec")
+	}
+
+	msg := receive(t, sub)
+	if msg.ContentType() != "application/json" || string(msg.Body) != `{"id":1,"title":"book"}` {
+		t.Errorf("got message %q of type %q", msg.Body, msg.ContentType())
+	}
+
+	var o order
+	if err := msg.Decode(&o); err != nil {
+		t.Fatal(err)
+	}
+	if o != (order{1, "book"}) {
+		t.Errorf("got %+v, want %+v", o, order{1, "book"})
+	}
+
+	var text string
+	if err := receive(t, sub).Decode(&text); err != nil {
+		t.Fatal(err)
+	}
+	if text != "plain" {
+		t.Errorf("got %q, want %q", text, "plain")
+	}
+}
+
+func TestSendValueCompress(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.Subscribe("/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := strings.Repeat("value ", 1000)
+	if err := conn.SendValue("/queue/test", large, stomp.Compress(stomp.Gzip)); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SendValue("/queue/test", "small", stomp.CompressAbove(stomp.Gzip, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the encoded values are compressed on the wire
+	frames, err := s.WaitFrames("SEND", 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range frames {
+		if f.Header["content-encoding"] != "gzip" || !strings.HasPrefix(string(f.Body), "\x1f\x8b") {
+			t.Errorf("got SEND frame %v %q, want compressed body", f.Header, f.Body)
+		}
+	}
+
+	for _, want := range []string{large, "small"} {
+		var got string
+		if err := receive(t, sub).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("got %d octets, want %q", len(got), want[:5])
+		}
+	}
+}
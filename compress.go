@@ -0,0 +1,160 @@
+package stomp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// Gzip is the content encoding of bodies compressed with gzip.
+const Gzip = "gzip"
+
+// DefaultCompressThreshold is the body size in octets below which Compress
+// leaves bodies uncompressed.
+const DefaultCompressThreshold = 1024
+
+// DefaultMaxDecompressedSize is the size in octets up to which received
+// bodies are decompressed if the Dialer does not set MaxDecompressedSize.
+const DefaultMaxDecompressedSize = 64 << 20
+
+// ErrBodyTooLarge is returned by a Compressor if a body decompresses to more
+// than the allowed size.
+var ErrBodyTooLarge = errors.New("stomp: decompressed body too large")
+
+// A Compressor compresses message bodies for a content encoding.
+type Compressor interface {
+	// Encoding returns the value of the content-encoding header of bodies
+	// produced by Compress.
+	Encoding() string
+
+	Compress(data []byte) ([]byte, error)
+
+	// Decompress decompresses data. It fails with ErrBodyTooLarge if the
+	// result exceeds max octets.
+	Decompress(data []byte, max int) ([]byte, error)
+}
+
+var compressors = struct {
+	sync.RWMutex
+	m map[string]Compressor
+}{m: make(map[string]Compressor)}
+
+func init() {
+	RegisterCompressor(gzipCompressor{})
+}
+
+// RegisterCompressor makes a compressor available for its content encoding,
+// replacing any compressor registered before for the same encoding.
+// Packages providing compressors usually register them in their init
+// function.
+func RegisterCompressor(c Compressor) {
+	compressors.Lock()
+	defer compressors.Unlock()
+	compressors.m[c.Encoding()] = c
+}
+
+func compressorFor(encoding string) (Compressor, bool) {
+	compressors.RLock()
+	defer compressors.RUnlock()
+	c, ok := compressors.m[encoding]
+	return c, ok
+}
+
+// Compress compresses the body of a frame with the compressor registered
+// for encoding and sets the content-encoding header, unless the body is
+// smaller than DefaultCompressThreshold. Received messages with a
+// registered content-encoding are decompressed automatically.
+//
+// The body is sent uncompressed if there is no compressor for encoding, if
+// compression fails or if the frame already has a content-encoding.
+func Compress(encoding string) Option {
+	return CompressAbove(encoding, DefaultCompressThreshold)
+}
+
+// CompressAbove is like Compress, but with a custom threshold.
+func CompressAbove(encoding string, threshold int) Option {
+	return func(f *Frame) {
+		if len(f.Body) < threshold || f.Header["content-encoding"] != "" {
+			return
+		}
+
+		c, ok := compressorFor(encoding)
+		if !ok {
+			return
+		}
+
+		body, err := c.Compress(f.Body)
+		if err != nil {
+			return
+		}
+
+		f.Body = body
+		f.Header["content-encoding"] = encoding
+		if _, ok := f.Header["content-length"]; ok {
+			f.Header["content-length"] = strconv.Itoa(len(body))
+		}
+	}
+}
+
+// decompress decompresses the body of f if it has a registered
+// content-encoding, up to max octets. Unknown encodings are left to the
+// application.
+func decompress(f *Frame, max int) error {
+	encoding := f.Header["content-encoding"]
+	if encoding == "" {
+		return nil
+	}
+
+	c, ok := compressorFor(encoding)
+	if !ok {
+		return nil
+	}
+
+	body, err := c.Decompress(f.Body, max)
+	if err != nil {
+		return fmt.Errorf("stomp: decompressing %s body: %v", encoding, err)
+	}
+
+	f.Body = body
+	delete(f.Header, "content-encoding")
+	if _, ok := f.Header["content-length"]; ok {
+		f.Header["content-length"] = strconv.Itoa(len(body))
+	}
+	return nil
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Encoding() string { return Gzip }
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte, max int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	body, err := io.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	} else if len(body) > max {
+		return nil, ErrBodyTooLarge
+	}
+	return body, nil
+}
@@ -0,0 +1,80 @@
+package stomp_test
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"github.com/cumulodev/stomp/stomptest"
+)
+
+func TestCompress(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.Subscribe("/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := bytes.Repeat([]byte(`{"key":"value"}`), 200)
+	for _, body := range [][]byte{large, []byte("small")} {
+		if err := conn.Send("/queue/test", "application/json", body, stomp.Compress(stomp.Gzip)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// large bodies are compressed on the wire, small ones are not
+	frames, err := s.WaitFrames("SEND", 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := frames[0]; f.Header["content-encoding"] != "gzip" || len(f.Body) >= len(large) || f.Header["content-length"] != strconv.Itoa(len(f.Body)) {
+		t.Errorf("got SEND frame %v with %d octets, want compressed body", f.Header, len(f.Body))
+	}
+	if f := frames[1]; f.Header["content-encoding"] != "" || string(f.Body) != "small" {
+		t.Errorf("got SEND frame %v %q, want uncompressed body", f.Header, f.Body)
+	}
+
+	// and decompressed on receipt
+	if msg := receive(t, sub); !bytes.Equal(msg.Body, large) || msg.Header["content-encoding"] != "" {
+		t.Errorf("got message %v with %d octets, want decompressed body", msg.Header, len(msg.Body))
+	}
+	if msg := receive(t, sub); string(msg.Body) != "small" {
+		t.Errorf("got message %q, want %q", msg.Body, "small")
+	}
+}
+
+func TestMaxDecompressedSize(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := (&stomp.Dialer{MaxDecompressedSize: 1024}).Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.Subscribe("/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the oversized body is rejected, the connection keeps working
+	for _, body := range [][]byte{bytes.Repeat([]byte("a"), 4096), bytes.Repeat([]byte("b"), 1024)} {
+		if err := conn.Send("/queue/test", "text/plain", body, stomp.CompressAbove(stomp.Gzip, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if msg := receive(t, sub); len(msg.Body) != 1024 || msg.Body[0] != 'b' {
+		t.Errorf("got message with %d octets, want the small one", len(msg.Body))
+	}
+}
@@ -0,0 +1,419 @@
+package stomp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The conformance tests replay golden wire transcripts of the STOMP 1.2
+// specification stored in testdata/conformance. Each line of a transcript
+// holds a line of a frame sent by the client ("C:") or the server ("S:"). A
+// frame ends with the line containing its NULL octet. Non-printable octets
+// are written in caret notation: "^@" is the NULL octet and "^M" is CR.
+//
+// Header values of client frames starting with "$" match any value and
+// store it in a variable, which is substituted in later server frames.
+
+var conformanceTests = []struct {
+	name    string
+	options []Option
+	run     func(t *testing.T, c *Conn)
+}{
+	{"connect", nil, nil},
+	{"connect-unescaped", []Option{Host("broker:61613"), Authenticate("us:er", `pa\ss`)}, nil},
+	{"heart-beat", []Option{Heartbeat(100, 200)}, func(t *testing.T, c *Conn) {
+		if hb := c.HeartBeat(); hb.Send != 100*time.Millisecond || hb.Recv != 300*time.Millisecond {
+			t.Errorf("got heart-beat %v,%v, want 100ms,300ms", hb.Send, hb.Recv)
+		}
+	}},
+	{"heart-beat-disabled", []Option{Heartbeat(0, 200)}, func(t *testing.T, c *Conn) {
+		if hb := c.HeartBeat(); hb.Send != 0 || hb.Recv != 0 {
+			t.Errorf("got heart-beat %v,%v, want 0s,0s", hb.Send, hb.Recv)
+		}
+	}},
+	{"send-escaping", nil, func(t *testing.T, c *Conn) {
+		note := func(f *Frame) { f.Header["note"] = "line\nbreak\r\\" }
+		if err := c.Send("/queue/a:b", "text/plain", []byte("hi"), note); err != nil {
+			t.Fatal(err)
+		}
+	}},
+	{"send-content-length", nil, func(t *testing.T, c *Conn) {
+		if err := c.Send("/queue/a", "application/octet-stream", []byte("a\x00b")); err != nil {
+			t.Fatal(err)
+		}
+	}},
+	{"message-crlf", nil, func(t *testing.T, c *Conn) {
+		msg := subscribeOne(t, c, "/queue/a")
+		want := Header{"subscription": msg.Subscription(), "message-id": "1", "destination": "/queue/a", "content-type": "text/plain"}
+		if !reflect.DeepEqual(msg.Header, want) || string(msg.Body) != "hello" {
+			t.Errorf("got message %v %q, want %v %q", msg.Header, msg.Body, want, "hello")
+		}
+	}},
+	{"message-escaping", nil, func(t *testing.T, c *Conn) {
+		msg := subscribeOne(t, c, "/queue/a:b")
+		if msg.Destination() != "/queue/a:b" || msg.Header["note"] != "line\nbreak\r\\" {
+			t.Errorf("got header %q, want decoded escape sequences", msg.Header)
+		}
+	}},
+	{"message-first-header", nil, func(t *testing.T, c *Conn) {
+		if msg := subscribeOne(t, c, "/queue/a"); msg.Header["foo"] != "first" {
+			t.Errorf("got foo header %q, want %q", msg.Header["foo"], "first")
+		}
+	}},
+	{"message-content-length", nil, func(t *testing.T, c *Conn) {
+		if msg := subscribeOne(t, c, "/queue/a"); string(msg.Body) != "ab\x00cd" {
+			t.Errorf("got body %q, want %q", msg.Body, "ab\x00cd")
+		}
+	}},
+	{"ack", nil, func(t *testing.T, c *Conn) {
+		sub, err := c.Subscribe("/queue/a", Ack(AckIndividual))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Ack(receiveMessage(t, sub)); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Nack(receiveMessage(t, sub)); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Unsubscribe(sub); err != nil {
+			t.Fatal(err)
+		}
+	}},
+}
+
+func TestConformanceConn(t *testing.T) {
+	for _, test := range conformanceTests {
+		t.Run(test.name, func(t *testing.T) {
+			steps := loadTranscript(t, test.name)
+			client, server := net.Pipe()
+
+			// the fake server plays its part of the transcript and
+			// discards everything after it
+			done := make(chan error, 1)
+			go func() {
+				server.SetDeadline(time.Now().Add(2 * time.Second))
+				done <- play(steps, server)
+				io.Copy(io.Discard, server)
+			}()
+
+			d := &Dialer{
+				NetDial: func(network, addr string) (net.Conn, error) { return client, nil },
+				Reconnect: func(int, time.Duration, error) (bool, time.Duration) {
+					return false, 0
+				},
+				Strict: true,
+			}
+			c, err := d.Dial("tcp", "localhost", test.options...)
+			if err != nil {
+				server.Close()
+				t.Fatalf("Dial: %v (transcript: %v)", err, <-done)
+			}
+			defer c.Close()
+
+			if test.run != nil {
+				test.run(t, c)
+			}
+
+			if err := <-done; err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestConformanceCodec(t *testing.T) {
+	for _, test := range conformanceTests {
+		for i, s := range loadTranscript(t, test.name) {
+			raw := strings.ReplaceAll(s.raw, "$sub", "0")
+
+			// every frame of a transcript passes a strict decoder
+			d := NewDecoder(strings.NewReader(raw))
+			d.Strict = true
+			f, err := d.Decode()
+			if err != nil {
+				t.Errorf("%s: frame %d: %v", test.name, i+1, err)
+				continue
+			}
+
+			// and the encoder reproduces it up to the order of headers
+			if s.client {
+				want, _ := readRaw(bufio.NewReader(strings.NewReader(raw)))
+				got, _ := readRaw(bufio.NewReader(strings.NewReader(string(encodeFrame(f)))))
+				if err := matchRaw(want, got, nil); err != nil {
+					t.Errorf("%s: frame %d: %v", test.name, i+1, err)
+				}
+			}
+		}
+	}
+}
+
+func TestConformanceRequiredHeaders(t *testing.T) {
+	for command, required := range requiredHeaders {
+		header := make(Header)
+		for _, key := range required {
+			header[key] = "x"
+		}
+
+		if err := checkHeader(command, header); err != nil {
+			t.Errorf("%s: %v", command, err)
+		}
+
+		for _, key := range required {
+			partial := make(Header)
+			for k, v := range header {
+				if k != key {
+					partial[k] = v
+				}
+			}
+
+			input := encodeFrame(&Frame{Command: command, Header: partial})
+			d := NewDecoder(strings.NewReader(string(input)))
+			d.Strict = true
+			if _, err := d.Decode(); !errors.Is(err, ErrProtocol) {
+				t.Errorf("%s without %s: got error %v, want protocol error", command, key, err)
+			}
+		}
+	}
+}
+
+func TestConformanceHeartBeat(t *testing.T) {
+	tests := []struct {
+		send, recv, want time.Duration
+	}{
+		{0, 0, 0},
+		{0, 100, 0},
+		{100, 0, 0},
+		{100, 200, 200},
+		{300, 200, 300},
+	}
+
+	for _, test := range tests {
+		if got := negotiateHeartBeat(test.send, test.recv); got != test.want {
+			t.Errorf("negotiateHeartBeat(%v, %v) = %v, want %v", test.send, test.recv, got, test.want)
+		}
+	}
+
+	for v, want := range map[string][2]time.Duration{
+		"100,200":   {100 * time.Millisecond, 200 * time.Millisecond},
+		" 100, 200": {100 * time.Millisecond, 200 * time.Millisecond},
+		"":          {0, 0},
+		"100":       {0, 0},
+		"-1,100":    {0, 0},
+	} {
+		if x, y := parseHeartBeat(v); x != want[0] || y != want[1] {
+			t.Errorf("parseHeartBeat(%q) = %v, %v, want %v, %v", v, x, y, want[0], want[1])
+		}
+	}
+}
+
+// step is a frame of a transcript.
+type step struct {
+	client bool
+	raw    string
+}
+
+func loadTranscript(t *testing.T, name string) []step {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "conformance", name+".txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var steps []step
+	var lines []string
+	var prefix string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p, text, ok := strings.Cut(line, ":")
+		if !ok || p != "C" && p != "S" || len(lines) > 0 && p != prefix {
+			t.Fatalf("%s: malformed line %q", name, line)
+		}
+
+		prefix = p
+		lines = append(lines, strings.TrimPrefix(text, " "))
+		if strings.HasSuffix(text, "^@") {
+			raw := strings.NewReplacer("^@", "\x00", "^M", "\r").Replace(strings.Join(lines, "\n"))
+			steps = append(steps, step{client: prefix == "C", raw: raw})
+			lines = nil
+		}
+	}
+
+	if len(lines) > 0 {
+		t.Fatalf("%s: unterminated frame", name)
+	}
+	return steps
+}
+
+// play acts as the server of a transcript on nc.
+func play(steps []step, nc net.Conn) error {
+	r := bufio.NewReader(nc)
+	vars := make(map[string]string)
+	for i, s := range steps {
+		if !s.client {
+			raw := s.raw
+			for name, value := range vars {
+				raw = strings.ReplaceAll(raw, "$"+name, value)
+			}
+
+			if _, err := io.WriteString(nc, raw); err != nil {
+				return fmt.Errorf("frame %d: %v", i+1, err)
+			}
+			continue
+		}
+
+		want, err := readRaw(bufio.NewReader(strings.NewReader(s.raw)))
+		if err != nil {
+			return fmt.Errorf("frame %d: malformed transcript: %v", i+1, err)
+		}
+
+		got, err := readRaw(r)
+		if err != nil {
+			return fmt.Errorf("frame %d: reading %s frame: %v", i+1, want.command, err)
+		}
+
+		if err := matchRaw(want, got, vars); err != nil {
+			return fmt.Errorf("frame %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// rawFrame is a frame with undecoded header lines.
+type rawFrame struct {
+	command string
+	header  map[string]string
+	body    string
+}
+
+// readRaw reads the next frame from r without decoding escape sequences.
+// Heart-beats are skipped.
+func readRaw(r *bufio.Reader) (*rawFrame, error) {
+	readLine := func() (string, error) {
+		line, err := r.ReadString('\n')
+		return strings.TrimSuffix(line, "\n"), err
+	}
+
+	command, err := readLine()
+	for err == nil && command == "" {
+		command, err = readLine()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f := &rawFrame{command: command, header: make(map[string]string)}
+	for {
+		line, err := readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			break
+		}
+
+		key, value, _ := strings.Cut(line, ":")
+		if _, ok := f.header[key]; ok {
+			return nil, fmt.Errorf("repeated %s header", key)
+		}
+		f.header[key] = value
+	}
+
+	if v, ok := f.header["content-length"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+
+		body := make([]byte, n+1)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, err
+		}
+		if body[n] != 0 {
+			return nil, errors.New("body not terminated by NULL octet")
+		}
+		f.body = string(body[:n])
+		return f, nil
+	}
+
+	f.body, err = r.ReadString(0)
+	if err != nil {
+		return nil, err
+	}
+	f.body = strings.TrimSuffix(f.body, "\x00")
+	return f, nil
+}
+
+// matchRaw compares a frame sent by the client to the expected frame of a
+// transcript and stores the values of variables in vars.
+func matchRaw(want, got *rawFrame, vars map[string]string) error {
+	if got.command != want.command {
+		return fmt.Errorf("got %s frame, want %s", got.command, want.command)
+	}
+
+	for _, key := range requiredHeaders[got.command] {
+		if _, ok := got.header[key]; !ok {
+			return fmt.Errorf("%s frame without mandatory %s header", got.command, key)
+		}
+	}
+
+	if len(got.header) != len(want.header) {
+		return fmt.Errorf("got %s header %q, want %q", got.command, got.header, want.header)
+	}
+
+	for key, value := range want.header {
+		v, ok := got.header[key]
+		if !ok {
+			return fmt.Errorf("got %s header %q, want %q", got.command, got.header, want.header)
+		}
+
+		if name, ok := strings.CutPrefix(value, "$"); ok {
+			if vars != nil {
+				vars[name] = v
+			}
+			continue
+		}
+
+		if v != value {
+			return fmt.Errorf("got %s header %s:%s, want %s:%s", got.command, key, v, key, value)
+		}
+	}
+
+	if got.body != want.body {
+		return fmt.Errorf("got %s body %q, want %q", got.command, got.body, want.body)
+	}
+	return nil
+}
+
+func subscribeOne(t *testing.T, c *Conn, destination string) *Message {
+	t.Helper()
+	sub, err := c.Subscribe(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return receiveMessage(t, sub)
+}
+
+func receiveMessage(t *testing.T, sub *Subscription) *Message {
+	t.Helper()
+	select {
+	case msg := <-sub.C:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for message")
+		return nil
+	}
+}
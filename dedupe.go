@@ -0,0 +1,226 @@
+package stomp
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultDedupeSize is the number of keys remembered by the default
+	// store of a Deduplicator.
+	DefaultDedupeSize = 10000
+
+	// DefaultDedupeTTL is how long the default store of a Deduplicator
+	// remembers a key.
+	DefaultDedupeTTL = time.Hour
+)
+
+// A DedupeStore remembers the keys of messages that were delivered. Its
+// methods may be called concurrently.
+type DedupeStore interface {
+	// Add records key and reports whether it was not recorded before.
+	Add(key string) (bool, error)
+
+	// Remove forgets key.
+	Remove(key string) error
+}
+
+// A Deduplicator filters duplicates of messages, as they occur with
+// at-least-once delivery after reconnects and NACKs. Duplicates are not
+// delivered. In the client-individual ack mode, they are acknowledged. In the
+// client ack mode, where an ACK would also acknowledge messages still being
+// processed, they are left to the next ACK of the application instead.
+//
+// A message is recorded when it is delivered. If it could not be
+// processed, call Forget before NACKing it, so its redelivery is not
+// filtered.
+type Deduplicator struct {
+	// Header is the header identifying a message. If empty, the
+	// message-id header is used. Messages without the header are always
+	// delivered.
+	Header string
+
+	// Store records the delivered messages. If nil, a MemoryDedupeStore
+	// with DefaultDedupeSize and DefaultDedupeTTL is used.
+	Store DedupeStore
+
+	once       sync.Once
+	duplicates atomic.Uint64
+}
+
+// Subscribe subscribes to a destination like Conn.Subscribe and filters
+// duplicates of received messages.
+func (d *Deduplicator) Subscribe(c *Conn, destination string, options ...Option) (*Subscription, error) {
+	sub, err := c.Subscribe(destination, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	d.init()
+	out := sub.derive()
+	go d.filter(c, sub.ack, sub.C, out.C, sub.state.done)
+	return out, nil
+}
+
+func (d *Deduplicator) init() {
+	d.once.Do(func() {
+		if d.Store == nil {
+			d.Store = NewMemoryDedupeStore(DefaultDedupeSize, DefaultDedupeTTL)
+		}
+	})
+}
+
+// Duplicates returns the number of duplicates filtered so far.
+func (d *Deduplicator) Duplicates() uint64 {
+	return d.duplicates.Load()
+}
+
+// Forget forgets that msg was delivered.
+func (d *Deduplicator) Forget(msg *Message) error {
+	key := d.key(msg)
+	if key == "" {
+		return nil
+	}
+	d.init()
+	return d.Store.Remove(key)
+}
+
+func (d *Deduplicator) key(msg *Message) string {
+	if d.Header == "" {
+		return msg.Id()
+	}
+	return msg.Header[d.Header]
+}
+
+func (d *Deduplicator) filter(c *Conn, ack AckMode, in <-chan *Message, out chan<- *Message, done <-chan struct{}) {
+	defer close(out)
+
+	for msg := range in {
+		if d.unique(c, msg) {
+			select {
+			case out <- msg:
+			case <-done:
+				// nobody is receiving from an ended subscription
+				return
+			}
+			continue
+		}
+
+		d.duplicates.Add(1)
+		key := d.key(msg)
+		c.logger.Debug("stomp: dropping duplicate message",
+			"key", key, "destination", msg.Destination(), "message-id", msg.Id())
+		msg.BodyReader().Close()
+		if ack == AckClient {
+			continue
+		}
+		if err := c.Ack(msg); err != nil {
+			c.logger.Warn("stomp: failed to ack duplicate message", "key", key, "err", err)
+		}
+	}
+}
+
+// unique reports whether msg is to be delivered, i.e. it has no key or its
+// key was not seen before.
+func (d *Deduplicator) unique(c *Conn, msg *Message) bool {
+	key := d.key(msg)
+	if key == "" {
+		return true
+	}
+
+	added, err := d.Store.Add(key)
+	if err != nil {
+		// rather deliver a duplicate than lose the message
+		c.logger.Warn("stomp: deduplication failed", "key", key, "err", err)
+		return true
+	}
+	return added
+}
+
+// MemoryDedupeStore is a DedupeStore keeping keys in memory. It remembers a
+// bounded number of keys for a limited time since they were last used,
+// forgetting the least recently used keys first.
+type MemoryDedupeStore struct {
+	size int
+	ttl  time.Duration
+
+	mu    sync.Mutex
+	order *list.List // of *dedupeEntry, most recently used first
+	keys  map[string]*list.Element
+}
+
+type dedupeEntry struct {
+	key   string
+	added time.Time
+}
+
+// NewMemoryDedupeStore returns a MemoryDedupeStore remembering up to size
+// keys for ttl each. A ttl of zero remembers keys until they are evicted.
+func NewMemoryDedupeStore(size int, ttl time.Duration) *MemoryDedupeStore {
+	return &MemoryDedupeStore{
+		size:  size,
+		ttl:   ttl,
+		order: list.New(),
+		keys:  make(map[string]*list.Element),
+	}
+}
+
+// Add implements the DedupeStore interface.
+func (s *MemoryDedupeStore) Add(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.expire(now)
+
+	if e, ok := s.keys[key]; ok {
+		// a duplicate renews the key
+		e.Value.(*dedupeEntry).added = now
+		s.order.MoveToFront(e)
+		return false, nil
+	}
+
+	s.keys[key] = s.order.PushFront(&dedupeEntry{key: key, added: now})
+	for s.order.Len() > s.size {
+		s.evict(s.order.Back())
+	}
+	return true, nil
+}
+
+// Remove implements the DedupeStore interface.
+func (s *MemoryDedupeStore) Remove(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.keys[key]; ok {
+		s.evict(e)
+	}
+	return nil
+}
+
+// Len returns the number of remembered keys.
+func (s *MemoryDedupeStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(time.Now())
+	return s.order.Len()
+}
+
+// expire forgets the keys older than the ttl. The caller must hold s.mu.
+func (s *MemoryDedupeStore) expire(now time.Time) {
+	if s.ttl <= 0 {
+		return
+	}
+	for e := s.order.Back(); e != nil; e = s.order.Back() {
+		if now.Sub(e.Value.(*dedupeEntry).added) < s.ttl {
+			return
+		}
+		s.evict(e)
+	}
+}
+
+func (s *MemoryDedupeStore) evict(e *list.Element) {
+	s.order.Remove(e)
+	delete(s.keys, e.Value.(*dedupeEntry).key)
+}
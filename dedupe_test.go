@@ -0,0 +1,161 @@
+package stomp_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"github.com/cumulodev/stomp/stomptest"
+)
+
+func TestDeduplicator(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	d := &stomp.Deduplicator{Header: "order-id"}
+	sub, err := d.Subscribe(conn, "/queue/test", stomp.Ack(stomp.AckIndividual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"1", "2", "1", "", "2", ""} {
+		s.Publish("/queue/test", []byte(id), stomp.Header{"order-id": id})
+	}
+
+	// duplicates are acknowledged but not delivered, messages without key
+	// are always delivered
+	var got []string
+	for range 4 {
+		msg := receive(t, sub)
+		got = append(got, string(msg.Body))
+		conn.Ack(msg)
+	}
+	if want := []string{"1", "2", "", ""}; !slices.Equal(got, want) {
+		t.Errorf("got messages %q, want %q", got, want)
+	}
+	if _, err := s.WaitFrames("ACK", 6, time.Second); err != nil {
+		t.Error(err)
+	}
+	if n := d.Duplicates(); n != 2 {
+		t.Errorf("got %d duplicates, want 2", n)
+	}
+
+	// forgotten messages are delivered again
+	msg := &stomp.Message{Frame: stomp.Frame{Header: stomp.Header{"order-id": "1"}}}
+	if err := d.Forget(msg); err != nil {
+		t.Fatal(err)
+	}
+	s.Publish("/queue/test", []byte("1"), stomp.Header{"order-id": "1"})
+	if msg := receive(t, sub); string(msg.Body) != "1" {
+		t.Errorf("got message %q, want %q", msg.Body, "1")
+	}
+}
+
+func TestMemoryDedupeStore(t *testing.T) {
+	s := stomp.NewMemoryDedupeStore(2, 50*time.Millisecond)
+
+	add := func(key string, want bool) {
+		t.Helper()
+		if added, err := s.Add(key); err != nil || added != want {
+			t.Errorf("Add(%q) = %v, %v, want %v", key, added, err, want)
+		}
+	}
+
+	add("a", true)
+	add("b", true)
+	add("a", false)
+
+	// the least recently used key is evicted
+	add("c", true)
+	add("b", true)
+	add("c", false)
+
+	// keys expire
+	time.Sleep(60 * time.Millisecond)
+	if n := s.Len(); n != 0 {
+		t.Errorf("got %d keys after ttl, want none", n)
+	}
+	add("a", true)
+}
+
+func TestDeduplicatorClientAck(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// forgetting before subscribing uses the default store
+	d := &stomp.Deduplicator{Header: "order-id"}
+	if err := d.Forget(&stomp.Message{Frame: stomp.Frame{Header: stomp.Header{"order-id": "1"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := d.Subscribe(conn, "/queue/test", stomp.Ack(stomp.AckClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"1", "1", "2"} {
+		s.Publish("/queue/test", []byte(id), stomp.Header{"order-id": id})
+	}
+
+	// the duplicate is not acknowledged, as that would acknowledge the
+	// first message before it is processed
+	first := receive(t, sub)
+	second := receive(t, sub)
+	if string(first.Body) != "1" || string(second.Body) != "2" {
+		t.Errorf("got messages %q and %q, want %q and %q", first.Body, second.Body, "1", "2")
+	}
+	if n := commands(s.Frames(), "ACK"); n != 0 {
+		t.Errorf("got %d ACK frames, want none", n)
+	}
+}
+
+func TestDeduplicatorUnsubscribe(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := (&stomp.Deduplicator{}).Subscribe(conn, "/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		s.Publish("/queue/test", []byte("hello"), nil)
+	}
+	receive(t, sub)
+
+	// the channel is closed although filtered messages were not received
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(time.Second)
+	for n := 0; ; n++ {
+		select {
+		case _, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if n > 0 {
+				t.Fatal("got several messages after unsubscribe")
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting for channel to close")
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package stomp
+
+import (
+	"hash/fnv"
+	"runtime"
+	"sync"
+)
+
+// A Dispatcher processes the messages of a subscription with a pool of
+// workers. Messages with the same key are processed one at a time in the
+// order they were received, messages with different keys in parallel.
+//
+// A message is acknowledged if the handler returns nil and NACKed
+// otherwise. For subscriptions with the cumulative client ack mode, a
+// message is only acknowledged once all messages received before it have
+// been processed, so an ACK never covers a message still in progress.
+type Dispatcher struct {
+	// Workers is the number of messages processed in parallel. If zero,
+	// runtime.GOMAXPROCS(0) is used.
+	Workers int
+
+	// Key returns the key of a message, e.g. the ID of the entity it
+	// refers to. If nil, messages are distributed over the workers without
+	// any ordering.
+	Key func(msg *Message) string
+
+	// Handler processes a message.
+	Handler func(msg *Message) error
+}
+
+// HeaderKey returns a Dispatcher key function returning the value of the
+// given header.
+func HeaderKey(name string) func(msg *Message) string {
+	return func(msg *Message) string {
+		return msg.Header[name]
+	}
+}
+
+// dispatchJob is a message numbered in the order it was received.
+type dispatchJob struct {
+	seq uint64
+	msg *Message
+}
+
+// Run processes the messages received from sub, which must be a
+// subscription of c, until its channel is closed and all messages are
+// processed.
+func (d Dispatcher) Run(c *Conn, sub *Subscription) {
+	workers := d.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	var settle func(seq uint64, msg *Message, err error)
+	if sub.ack == AckClient {
+		acker := &cumulativeAcker{conn: c, done: make(map[uint64]settled)}
+		settle = acker.settle
+	} else {
+		settle = func(_ uint64, msg *Message, err error) {
+			c.settle(msg, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	queues := make([]chan dispatchJob, workers)
+	for i := range queues {
+		queues[i] = make(chan dispatchJob, 16)
+		wg.Add(1)
+		go func(jobs <-chan dispatchJob) {
+			defer wg.Done()
+			for job := range jobs {
+				settle(job.seq, job.msg, d.Handler(job.msg))
+			}
+		}(queues[i])
+	}
+
+	var seq uint64
+	for msg := range sub.C {
+		i := int(seq % uint64(workers))
+		if d.Key != nil {
+			h := fnv.New32a()
+			h.Write([]byte(d.Key(msg)))
+			i = int(h.Sum32() % uint32(workers))
+		}
+
+		queues[i] <- dispatchJob{seq: seq, msg: msg}
+		seq++
+	}
+
+	for _, q := range queues {
+		close(q)
+	}
+	wg.Wait()
+}
+
+// settle acknowledges msg if it was processed without error and NACKs it
+// otherwise.
+func (c *Conn) settle(msg *Message, err error) {
+	if err != nil {
+		c.logger.Warn("stomp: message processing failed", "message-id", msg.Id(), "err", err)
+		err = c.Nack(msg)
+	} else {
+		err = c.Ack(msg)
+	}
+	if err != nil {
+		c.logger.Warn("stomp: failed to settle message", "message-id", msg.Id(), "err", err)
+	}
+}
+
+// settled is the result of processing a message.
+type settled struct {
+	msg *Message
+	err error
+}
+
+// cumulativeAcker settles messages of a subscription in the client ack
+// mode in the order they were received, as ACK and NACK frames cover all
+// messages received before.
+type cumulativeAcker struct {
+	conn *Conn
+
+	mu   sync.Mutex
+	next uint64
+	done map[uint64]settled
+}
+
+func (a *cumulativeAcker) settle(seq uint64, msg *Message, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.done[seq] = settled{msg: msg, err: err}
+
+	// acknowledge the longest run of processed messages with a single ACK
+	// frame, but NACK failed messages on their own
+	var last *Message
+	for {
+		s, ok := a.done[a.next]
+		if !ok {
+			break
+		}
+		delete(a.done, a.next)
+		a.next++
+
+		if s.err == nil {
+			last = s.msg
+			continue
+		}
+		if last != nil {
+			a.conn.settle(last, nil)
+			last = nil
+		}
+		a.conn.settle(s.msg, s.err)
+	}
+
+	if last != nil {
+		a.conn.settle(last, nil)
+	}
+}
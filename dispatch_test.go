@@ -0,0 +1,123 @@
+package stomp_test
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"github.com/cumulodev/stomp/stomptest"
+)
+
+func TestDispatcher(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := conn.Subscribe("/queue/test", stomp.Ack(stomp.AckClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 40
+	for i := range n {
+		s.Publish("/queue/test", []byte(strconv.Itoa(i)), stomp.Header{"entity": strconv.Itoa(i % 4)})
+	}
+
+	var (
+		mu        sync.Mutex
+		received  = make(map[string]int) // ack id -> position
+		processed = make(map[string][]int)
+		failed    bool
+	)
+	key := stomp.HeaderKey("entity")
+	d := stomp.Dispatcher{
+		Workers: 3,
+		Key: func(msg *stomp.Message) string {
+			mu.Lock()
+			received[msg.AckID()] = len(received)
+			mu.Unlock()
+			return key(msg)
+		},
+		Handler: func(msg *stomp.Message) error {
+			time.Sleep(time.Duration(rand.Intn(2000)) * time.Microsecond)
+			i, _ := strconv.Atoi(string(msg.Body))
+
+			mu.Lock()
+			defer mu.Unlock()
+			if i == 13 {
+				// fail the first delivery, the message is redelivered
+				// at the end
+				if failed = !failed; failed {
+					processed[key(msg)] = append(processed[key(msg)], i)
+					return errors.New("failed")
+				}
+				return nil
+			}
+			processed[key(msg)] = append(processed[key(msg)], i)
+			return nil
+		},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		d.Run(conn, sub)
+		close(done)
+	}()
+
+	// the redelivered message is acknowledged last
+	var frames []*stomp.Frame
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		frames = s.Frames()
+		mu.Lock()
+		pos, ok := received[frames[len(frames)-1].Header["id"]]
+		mu.Unlock()
+		if frames[len(frames)-1].Command == "ACK" && ok && pos == n {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	conn.Close()
+	<-done
+
+	// messages of an entity are processed in order
+	for entity, seq := range processed {
+		for i := 1; i < len(seq); i++ {
+			if seq[i] != seq[i-1]+4 {
+				t.Errorf("entity %s: got order %v, want sequential", entity, seq)
+				break
+			}
+		}
+		if len(seq) != n/4 {
+			t.Errorf("entity %s: got %d messages, want %d", entity, len(seq), n/4)
+		}
+	}
+
+	// cumulative acknowledgments are sent in order of receipt
+	last := -1
+	var nacked []int
+	for _, f := range frames {
+		if f.Command != "ACK" && f.Command != "NACK" {
+			continue
+		}
+		pos := received[f.Header["id"]]
+		if pos <= last {
+			t.Errorf("got %s of message %d after message %d", f.Command, pos, last)
+		}
+		last = pos
+		if f.Command == "NACK" {
+			nacked = append(nacked, pos)
+		}
+	}
+	if last != n || len(nacked) != 1 {
+		t.Errorf("got last acknowledgment %d and NACKs %v, want %d and one NACK", last, nacked, n)
+	}
+}
@@ -2,12 +2,38 @@ package stomp
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"math"
 	"math/rand"
-	"net"
 	"time"
 )
 
+// ErrProtocol is the error matched by all errors reporting a malformed frame
+// received from the peer. Use errors.Is to test for it.
+var ErrProtocol = errors.New("stomp: protocol error")
+
+// A ProtocolError reports a malformed frame received from the peer.
+type ProtocolError struct {
+	// Line is the offending line of the frame, if any.
+	Line string
+
+	// Msg describes the violation of the protocol.
+	Msg string
+}
+
+func (e *ProtocolError) Error() string {
+	if e.Line != "" {
+		return fmt.Sprintf("stomp: protocol error: %s in line %q", e.Msg, e.Line)
+	}
+	return fmt.Sprintf("stomp: protocol error: %s", e.Msg)
+}
+
+// Is reports whether target is ErrProtocol.
+func (e *ProtocolError) Is(target error) bool {
+	return target == ErrProtocol
+}
+
 // ExponentialBackoffReconnect uses the exponential backoff algorithm that
 // uses feedback to multiplicatively decrease the rate of reconnects until
 // either a success or an acceptable rate has been found.
@@ -21,12 +47,26 @@ func ExponentialBackoffReconnect(n int, d time.Duration, err error) (bool, time.
 }
 
 func (c *Conn) error(err error) {
+	c.mu.Lock()
+	if c.closed || c.reconnecting {
+		// errors caused by Close or by a failure that is already
+		// being handled are ignored
+		c.mu.Unlock()
+		return
+	}
+
+	// stop read & write loop while reconnecting
+	c.reconnecting = true
+	close(c.closeC)
+	conn := c.conn
+	c.mu.Unlock()
+
+	// unblock pending reads and writes of the broken connection
+	conn.Close()
+	c.logger.Warn("stomp: connection lost", "addr", c.addr, "err", err)
+
 	go func() {
-		// stop read & write loop while reconnecting
-		close(c.closeC)
-		c.writeC = make(chan frame)
-		c.readC = make(chan int, 1)
-		c.closeC = make(chan struct{})
+		c.loops.Wait()
 
 		var (
 			n     = 1
@@ -36,17 +76,35 @@ func (c *Conn) error(err error) {
 
 		for ; err != nil; err = c.reconnect() {
 			if ok, sleep = c.Reconnect(n, sleep, err); !ok {
+				c.logger.Error("stomp: giving up reconnecting", "addr", c.addr, "attempt", n, "err", err)
 				c.Err = err
 				c.Close()
 				return
 			}
 
+			if c.isClosed() {
+				return
+			}
+
+			c.logger.Info("stomp: reconnecting", "addr", c.addr, "attempt", n, "delay", sleep, "cause", err)
+			c.metrics.ReconnectAttempt()
+
 			n = n + 1
 			time.Sleep(sleep)
 		}
 
-		go c.readLoop()
-		go c.writeLoop()
+		c.mu.Lock()
+		if c.closed {
+			// Close was called while reconnecting
+			c.conn.Close()
+			c.mu.Unlock()
+			return
+		}
+		c.reconnecting = false
+		c.start()
+		c.mu.Unlock()
+
+		c.logger.Info("stomp: reconnected", "addr", c.addr, "attempts", n)
 
 		if c.ReconnectSuccess != nil {
 			c.ReconnectSuccess(n)
@@ -54,16 +112,26 @@ func (c *Conn) error(err error) {
 	}()
 }
 
+func (c *Conn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
 func (c *Conn) reconnect() error {
-	conn, err := net.Dial(c.network, c.addr)
+	conn, err := c.dial(c.network, c.addr)
 	if err != nil {
 		return err
 	}
 
+	c.mu.Lock()
 	c.conn = conn
-	c.reader = bufio.NewReader(conn)
+	c.decoder = &Decoder{Strict: c.strict, reader: bufio.NewReader(conn), stream: c.streamThreshold}
+	c.mu.Unlock()
+
 	err = c.connect(c.options)
 	if err != nil {
+		conn.Close()
 		return err
 	}
 
@@ -80,7 +148,12 @@ func (c *Conn) reconnect() error {
 			},
 		}
 
-		if err := c.unsafeWrite(frame, sub.options...); err != nil {
+		ok, err := c.outbound(frame, sub.options)
+		if err == nil && ok {
+			err = c.unsafeWrite(frame)
+		}
+		if err != nil {
+			conn.Close()
 			return err
 		}
 	}
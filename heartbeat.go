@@ -0,0 +1,164 @@
+package stomp
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHeartBeat is the heart beat interval offered to the server
+	// unless changed by the Heartbeat option.
+	defaultHeartBeat = 5 * time.Second
+
+	// defaultHeartBeatGrace is the factor applied to the negotiated
+	// intervals to tolerate network latency and timing inaccuracies.
+	defaultHeartBeatGrace = 2
+)
+
+// HeartBeat describes the heart-beating of a connection.
+type HeartBeat struct {
+	// Send is the negotiated interval between heart-beats sent to the
+	// server. Zero means no heart-beats are sent.
+	Send time.Duration
+
+	// Recv is the negotiated interval between heart-beats expected from
+	// the server. Zero means no heart-beats are expected.
+	Recv time.Duration
+
+	// Missed is the number of heart-beats from the server that did not
+	// arrive in time over the lifetime of the connection.
+	Missed int
+}
+
+// heartbeatMonitor negotiates and monitors the heart-beating of a connection
+// as described in the "Heart-beating" section of the STOMP 1.2 specification.
+type heartbeatMonitor struct {
+	grace  float64
+	misses int
+
+	mu     sync.Mutex
+	send   time.Duration
+	recv   time.Duration
+	missed int // consecutive
+	total  int
+}
+
+func newHeartbeatMonitor(grace float64, misses int) *heartbeatMonitor {
+	if grace <= 0 {
+		grace = defaultHeartBeatGrace
+	}
+	if misses <= 0 {
+		misses = 1
+	}
+
+	return &heartbeatMonitor{
+		grace:  grace,
+		misses: misses,
+		send:   defaultHeartBeat,
+		recv:   defaultHeartBeat,
+	}
+}
+
+// reset restores the default intervals used while connecting.
+func (h *heartbeatMonitor) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.send, h.recv = defaultHeartBeat, defaultHeartBeat
+	h.missed = 0
+}
+
+// negotiate sets the effective intervals from the heart-beat header values
+// of the CONNECT and CONNECTED frames.
+func (h *heartbeatMonitor) negotiate(client, server string) {
+	cx, cy := parseHeartBeat(client)
+	sx, sy := parseHeartBeat(server)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.send = negotiateHeartBeat(cx, sy)
+	h.recv = negotiateHeartBeat(cy, sx)
+	h.missed = 0
+}
+
+// state returns the effective intervals and the total of missed beats.
+func (h *heartbeatMonitor) state() HeartBeat {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return HeartBeat{Send: h.send, Recv: h.recv, Missed: h.total}
+}
+
+// sendInterval returns the interval between heart-beats sent to the server.
+func (h *heartbeatMonitor) sendInterval() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.send
+}
+
+// window returns how long to wait for data from the server before a
+// heart-beat counts as missed. Zero means no heart-beats are expected.
+func (h *heartbeatMonitor) window() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return time.Duration(float64(h.recv) * h.grace)
+}
+
+// readTimeout returns the timeout for reads from the network connection.
+// It only expires after the server is considered dead by miss.
+func (h *heartbeatMonitor) readTimeout() time.Duration {
+	return h.window() * time.Duration(h.misses+1)
+}
+
+// writeTimeout returns the timeout for writes to the network connection.
+func (h *heartbeatMonitor) writeTimeout() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return time.Duration(float64(h.send) * h.grace)
+}
+
+// received records that data from the server arrived.
+func (h *heartbeatMonitor) received() {
+	h.mu.Lock()
+	h.missed = 0
+	h.mu.Unlock()
+}
+
+// miss records a missed heart-beat and reports whether too many beats in a
+// row were missed.
+func (h *heartbeatMonitor) miss() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.missed++
+	h.total++
+	return h.missed >= h.misses
+}
+
+// parseHeartBeat parses the value of a heart-beat header. Missing or
+// malformed values disable heart-beating.
+func parseHeartBeat(v string) (time.Duration, time.Duration) {
+	x, y, ok := strings.Cut(v, ",")
+	if !ok {
+		return 0, 0
+	}
+
+	nx, err := strconv.Atoi(strings.TrimSpace(x))
+	if err != nil || nx < 0 {
+		return 0, 0
+	}
+	ny, err := strconv.Atoi(strings.TrimSpace(y))
+	if err != nil || ny < 0 {
+		return 0, 0
+	}
+	return time.Duration(nx) * time.Millisecond, time.Duration(ny) * time.Millisecond
+}
+
+// negotiateHeartBeat returns the interval between heart-beats sent by one
+// side that can send every send and the other side wanting one every recv.
+// Heart-beating is disabled if either of them is zero.
+func negotiateHeartBeat(send, recv time.Duration) time.Duration {
+	if send == 0 || recv == 0 {
+		return 0
+	}
+	return max(send, recv)
+}
@@ -0,0 +1,48 @@
+package stomp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"github.com/cumulodev/stomp/stomptest"
+)
+
+func TestHeartBeatMisses(t *testing.T) {
+	s := stomptest.NewUnstartedServer()
+	s.SendHeartBeat = 20 * time.Millisecond
+	s.Start()
+	defer s.Close()
+
+	dialer := stomptest.NewFaultDialer()
+	r := newReconnects(3)
+	d := r.dialer(dialer.Dial)
+	d.HeartBeatGrace = 1.5
+	d.HeartBeatMisses = 4
+	conn, err := d.Dial("tcp", s.Addr, stomp.Heartbeat(0, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if hb := conn.HeartBeat(); hb.Send != 0 || hb.Recv != 20*time.Millisecond {
+		t.Fatalf("got heart-beat %v,%v, want 0s,20ms", hb.Send, hb.Recv)
+	}
+
+	// a gap of two heart-beats is tolerated, but counted
+	dialer.Conns()[0].Inject(stomptest.DelayAfter(stomptest.Read, 0, 70*time.Millisecond))
+	select {
+	case err := <-r.errs:
+		t.Fatalf("reconnected after missed heart-beats: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if missed := conn.HeartBeat().Missed; missed == 0 {
+		t.Error("missed heart-beats not counted")
+	}
+
+	// a stalled server is detected after four missed heart-beats
+	dialer.Conns()[0].Inject(stomptest.StallAfter(stomptest.Read, 0))
+	r.err(t)
+	r.wait(t)
+}
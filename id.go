@@ -0,0 +1,32 @@
+package stomp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+)
+
+// randID returns a random identifier for subscriptions, receipts, chunk
+// groups and producers. It has 128 bits of entropy, so collisions between
+// clients sharing a server can be ruled out.
+func randID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("stomp: reading random bytes: " + err.Error())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// stampMessageID sets the message ID header on f unless it already has
+// one. It is called before the frame is first written, so the ID is kept
+// when the frame is resent from the reconnect buffer or the outbox. A retry
+// of Send by the caller stamps a new ID.
+func (c *Conn) stampMessageID(f *Frame) {
+	if c.messageIDHeader == "" {
+		return
+	}
+	if _, ok := f.Header[c.messageIDHeader]; ok {
+		return
+	}
+	f.Header[c.messageIDHeader] = c.producerID + ":" + strconv.FormatUint(c.sequence.Add(1), 10)
+}
@@ -0,0 +1,54 @@
+package stomp_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"github.com/cumulodev/stomp/stomptest"
+)
+
+func TestMessageIDHeader(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	d := &stomp.Dialer{MessageIDHeader: "_AMQ_DUPL_ID"}
+	conn, err := d.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for range 3 {
+		if err := conn.Send("/queue/test", "text/plain", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := conn.Send("/queue/test", "text/plain", nil, func(f *stomp.Frame) {
+		f.Header["_AMQ_DUPL_ID"] = "custom"
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	frames, err := s.WaitFrames("SEND", 4, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var producer string
+	for i, f := range frames[:3] {
+		id, seq, ok := strings.Cut(f.Header["_AMQ_DUPL_ID"], ":")
+		if !ok || len(id) != 32 || seq != strconv.Itoa(i+1) {
+			t.Errorf("got message ID %q, want producer ID and sequence %d", f.Header["_AMQ_DUPL_ID"], i+1)
+		}
+		if producer != "" && id != producer {
+			t.Errorf("got producer ID %q, want %q", id, producer)
+		}
+		producer = id
+	}
+	if id := frames[3].Header["_AMQ_DUPL_ID"]; id != "custom" {
+		t.Errorf("got message ID %q, want %q", id, "custom")
+	}
+}
@@ -0,0 +1,103 @@
+package stomp
+
+import (
+	"errors"
+	"strconv"
+)
+
+// ErrDropFrame is returned by an Interceptor to silently discard a frame.
+var ErrDropFrame = errors.New("stomp: drop frame")
+
+// An Interceptor inspects a frame sent or received by a connection and may
+// modify it in place. Returning ErrDropFrame discards the frame, any other
+// error rejects it.
+//
+// A rejected outbound frame is not sent and the error is returned to the
+// caller, e.g. of Send. A rejected inbound frame is not processed but passed
+// to the OnReject callback of the Dialer, if any. A
+// rejected message of a subscription in the client-individual ack mode is
+// NACKed so the server can redeliver or dead-letter it. In the client ack
+// mode a NACK would cover the messages received before, so the message is
+// left unacknowledged until a later ACK or NACK of the subscription.
+type Interceptor func(f *Frame) error
+
+// outbound applies the options and the outbound interceptors to f. It
+// reports false if an interceptor dropped the frame.
+func (c *Conn) outbound(f *Frame, options []Option) (bool, error) {
+	for _, fn := range options {
+		fn(f)
+	}
+
+	ok, err := intercept(c.outboundInterceptors, f)
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	if f.source != nil {
+		// the body of a streamed frame is written as is
+		if len(f.Body) > 0 {
+			return false, errors.New("stomp: body set on streamed frame")
+		}
+		f.Header["content-length"] = strconv.FormatInt(f.sourceSize, 10)
+		return true, nil
+	}
+
+	// options and interceptors may have changed the body
+	if _, ok := f.Header["content-length"]; ok {
+		f.Header["content-length"] = strconv.Itoa(len(f.Body))
+	}
+	return true, nil
+}
+
+// inbound applies the inbound interceptors to f and decompresses the body
+// of messages. It reports whether f is to be processed.
+func (c *Conn) inbound(f *Frame) bool {
+	ok, err := intercept(c.inboundInterceptors, f)
+	if err == nil && ok && f.Command == "MESSAGE" && f.stream == nil && !c.disableDecompression {
+		err = decompress(f, c.maxDecompressedSize)
+	}
+	if err == nil {
+		// interceptors and decompression may have changed the body
+		if _, set := f.Header["content-length"]; ok && set && f.stream == nil {
+			f.Header["content-length"] = strconv.Itoa(len(f.Body))
+		}
+		return ok
+	}
+
+	c.logger.Warn("stomp: inbound frame rejected", "command", f.Command, "err", err)
+	if c.onReject != nil {
+		c.onReject(f, err)
+	}
+	if f.Command == "MESSAGE" {
+		c.nackRejected(f)
+	}
+	return false
+}
+
+// nackRejected NACKs the rejected message f if its subscription is in the
+// client-individual ack mode.
+func (c *Conn) nackRejected(f *Frame) {
+	c.subsMu.Lock()
+	sub, ok := c.subs[f.Header["subscription"]]
+	c.subsMu.Unlock()
+
+	id := f.Header["ack"]
+	if !ok || sub.ack != AckIndividual || id == "" {
+		return
+	}
+
+	if err := c.safeWrite(&Frame{Command: "NACK", Header: Header{"id": id}}); err != nil {
+		c.logger.Warn("stomp: failed to NACK rejected message", "message-id", f.Header["message-id"], "err", err)
+	}
+}
+
+func intercept(interceptors []Interceptor, f *Frame) (bool, error) {
+	for _, fn := range interceptors {
+		if err := fn(f); err == ErrDropFrame {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,151 @@
+package stomp_test
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"github.com/cumulodev/stomp/stomptest"
+)
+
+func TestInterceptors(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	errForbidden := errors.New("forbidden destination")
+	d := &stomp.Dialer{
+		Outbound: []stomp.Interceptor{
+			func(f *stomp.Frame) error {
+				f.Header["tenant"] = "acme"
+				return nil
+			},
+			func(f *stomp.Frame) error {
+				switch f.Header["destination"] {
+				case "/queue/forbidden":
+					return errForbidden
+				case "/queue/void":
+					return stomp.ErrDropFrame
+				}
+				return nil
+			},
+		},
+		Inbound: []stomp.Interceptor{
+			func(f *stomp.Frame) error {
+				if string(f.Body) == "secret" {
+					return stomp.ErrDropFrame
+				}
+				f.Body = []byte(strings.ToUpper(string(f.Body)) + "!")
+				return nil
+			},
+		},
+	}
+
+	conn, err := d.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.Subscribe("/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rejected frames fail without breaking the connection
+	if err := conn.Send("/queue/forbidden", "text/plain", nil); err != errForbidden {
+		t.Errorf("got Send error %v, want %v", err, errForbidden)
+	}
+	if err := conn.Send("/queue/void", "text/plain", nil); err != nil {
+		t.Errorf("got Send error %v for dropped frame, want nil", err)
+	}
+
+	for _, body := range []string{"secret", "hello"} {
+		if err := conn.Send("/queue/test", "text/plain", []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msg := receive(t, sub)
+	if string(msg.Body) != "HELLO!" {
+		t.Errorf("got message %q, want %q", msg.Body, "HELLO!")
+	}
+	if n := msg.Header["content-length"]; n != "6" {
+		t.Errorf("got content-length %q, want %q", n, "6")
+	}
+
+	frames, err := s.WaitFrames("SEND", 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range frames {
+		if f.Header["destination"] != "/queue/test" || f.Header["tenant"] != "acme" {
+			t.Errorf("got SEND frame %v, want tenant header on /queue/test", f.Header)
+		}
+	}
+
+	connects, err := s.WaitFrames("CONNECT", 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if connects[0].Header["tenant"] != "acme" {
+		t.Error("CONNECT frame not intercepted")
+	}
+}
+
+func TestInterceptorsRejectMessage(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	// reject the first delivery of each message with body "bad"
+	var rejected atomic.Int32
+	d := &stomp.Dialer{
+		Inbound: []stomp.Interceptor{
+			func(f *stomp.Frame) error {
+				if string(f.Body) != "bad" || f.Header["redelivered"] != "" {
+					return nil
+				}
+				rejected.Add(1)
+				return errors.New("rejected")
+			},
+		},
+	}
+
+	conn, err := d.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// a rejected message is NACKed in the client-individual ack mode
+	individual, err := conn.Subscribe("/queue/individual", stomp.Ack(stomp.AckIndividual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Publish("/queue/individual", []byte("bad"), nil)
+	if _, err := s.WaitFrames("NACK", 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, individual); msg.Header["redelivered"] != "true" {
+		t.Errorf("got message %v, want redelivery", msg.Header)
+	}
+
+	// in the client ack mode it is left unacknowledged
+	client, err := conn.Subscribe("/queue/client", stomp.Ack(stomp.AckClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Publish("/queue/client", []byte("bad"), nil)
+	s.Publish("/queue/client", []byte("good"), nil)
+	if msg := receive(t, client); string(msg.Body) != "good" {
+		t.Errorf("got message %q, want %q", msg.Body, "good")
+	}
+	if n := commands(s.Frames(), "NACK"); n != 1 {
+		t.Errorf("got %d NACK frames, want 1", n)
+	}
+	if n := rejected.Load(); n != 2 {
+		t.Errorf("got %d rejected messages, want 2", n)
+	}
+}
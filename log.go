@@ -0,0 +1,17 @@
+package stomp
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discardLogger is used if no Logger is configured on the Dialer.
+var discardLogger = slog.New(discardHandler{})
+
+// discardHandler is a slog.Handler that drops all records.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
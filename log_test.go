@@ -0,0 +1,62 @@
+package stomp_test
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cumulodev/stomp/stomptest"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use by a log handler.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestLogger(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	var out syncBuffer
+	r := newReconnects(3)
+	d := r.dialer(nil)
+	d.Logger = slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	conn, err := d.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.DropConnections()
+	r.err(t)
+	r.wait(t)
+	conn.Close()
+
+	for _, want := range []string{
+		`msg="stomp: connected"`,
+		"version=1.2",
+		`msg="stomp: connection lost"`,
+		`msg="stomp: reconnecting" addr=` + s.Addr + " attempt=1",
+		`msg="stomp: reconnected"`,
+		`msg="stomp: closing connection"`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("log does not contain %s:\n%s", want, out.String())
+		}
+	}
+}
@@ -5,49 +5,117 @@ import (
 	"time"
 )
 
-func (c *Conn) writeLoop() {
+func (c *Conn) writeLoop(closeC chan struct{}, writeC chan frame) {
+	defer c.loops.Done()
+
+	// flush the frames buffered while reconnecting first
+	for {
+		frame, ok := c.buffer.pop()
+		if !ok {
+			break
+		}
+		if err := c.writeFrame(frame); err != nil {
+			c.error(err)
+			return
+		}
+	}
+
 	for {
 		select {
-		case <-c.closeC:
+		case <-closeC:
 			return
 
-		case <-timeout(c.whb):
+		case <-timeout(c.heartbeat.sendInterval()):
 			err := c.unsafeWrite(&Frame{})
 			if err != nil {
 				c.error(err)
+				return
 			}
 
-		case frame := <-c.writeC:
-			err := c.unsafeWrite(frame.body, frame.options...)
-			if err != nil {
+		case frame := <-writeC:
+			if err := c.writeFrame(frame); err != nil {
 				c.error(err)
+				return
 			}
+		}
+	}
+}
+
+// writeFrame writes a frame passed to safeWrite and reports its result to
+// the caller. It returns an error if the connection failed.
+func (c *Conn) writeFrame(frame frame) error {
+	if frame.batch != nil {
+		return c.writeBatch(frame)
+	}
 
-			frame.ch <- err
+	ok, err := c.outbound(frame.body, frame.options)
+	if err != nil || !ok {
+		// rejected or dropped by an interceptor, a dropped frame does not
+		// need a receipt
+		if id, ok := frame.body.Header["receipt"]; ok && err == nil {
+			c.receipts.received(id)
 		}
+		frame.ch <- err
+		return nil
 	}
+
+	err = c.unsafeWrite(frame.body)
+	frame.ch <- err
+	return err
 }
 
-func (c *Conn) readLoop() {
+func (c *Conn) readLoop(closeC chan struct{}, frames chan *Frame, errC chan error) {
+	defer c.loops.Done()
+
 	for {
 		select {
-		case <-c.closeC:
+		case <-closeC:
 			return
 
-		case <-timeout(2 * c.rhb):
-			c.error(errors.New("no heartbeat received"))
+		case <-timeout(c.heartbeat.window()):
+			if c.streaming.Load() {
+				// the server is busy sending a body, which is watched
+				// by the read deadline
+				continue
+			}
+
+			dead := c.heartbeat.miss()
+			c.metrics.HeartBeatMissed()
+			c.logger.Warn("stomp: missed heart-beat", "addr", c.addr, "missed", c.heartbeat.state().Missed)
+			if dead {
+				c.error(errors.New("no heartbeat received"))
+				return
+			}
+
+		case err := <-errC:
+			c.error(err)
+			return
+
+		case frame := <-frames:
+			c.heartbeat.received()
+			if frame.Command == "" {
+				continue
+			}
+			if !c.inbound(frame) {
+				frame.discard()
+				continue
+			}
 
-		case frame := <-c.safeRead():
 			switch frame.Command {
 			case "MESSAGE":
-				c.dispatchMessage(frame)
+				if !c.dispatchMessage(frame) {
+					frame.discard()
+				}
+
+			case "RECEIPT":
+				if d, ok := c.receipts.received(frame.Header["receipt-id"]); ok {
+					c.metrics.ReceiptLatency(d)
+				}
 
 			case "ERROR":
+				c.logger.Error("stomp: received ERROR frame", "addr", c.addr, "message", frame.Header["message"])
 				c.error(NewError(frame))
 			}
-
-			//NOTE: if a default case is created, do not forget
-			// about an empty frame (=heartbeat)
 		}
 	}
 }
@@ -60,11 +128,53 @@ func timeout(d time.Duration) <-chan time.Time {
 	return time.After(d)
 }
 
-func (c *Conn) dispatchMessage(frame *Frame) {
+// dispatchMessage delivers a message to its subscription and reports
+// whether it was delivered.
+func (c *Conn) dispatchMessage(frame *Frame) bool {
+	msg := &Message{Frame: *frame, conn: c}
+
 	c.subsMu.Lock()
-	msg := &Message{*frame}
-	if sub, ok := c.subs[msg.Subscription()]; ok {
-		sub.C <- msg
-	}
+	sub, ok := c.subs[msg.Subscription()]
 	c.subsMu.Unlock()
+	if !ok {
+		c.logger.Warn("stomp: dropping message for unknown subscription",
+			"subscription", msg.Subscription(), "destination", msg.Destination(), "message-id", msg.Id())
+		c.metrics.MessageDropped(msg.Destination())
+		return false
+	}
+
+	msg.mode = sub.ack
+	// false if unsubscribed or closed in the meantime
+	return c.deliver(sub, msg)
+}
+
+// deliver sends msg to the channel of sub and reports whether it was sent
+// before sub ended.
+func (c *Conn) deliver(sub *Subscription, msg *Message) bool {
+	sub.state.mu.Lock()
+	defer sub.state.mu.Unlock()
+
+	select {
+	case <-sub.state.done:
+		return false
+	default:
+	}
+
+	select {
+	case sub.C <- msg:
+		c.metrics.QueueDepth(sub.id, sub.destination, len(sub.C))
+		return true
+	default:
+	}
+
+	// the buffer of the subscription is full, block the connection until
+	// the consumer catches up or the subscription ends
+	c.logger.Warn("stomp: slow consumer", "subscription", sub.id, "destination", sub.destination, "buffered", len(sub.C))
+	select {
+	case sub.C <- msg:
+		c.metrics.QueueDepth(sub.id, sub.destination, len(sub.C))
+		return true
+	case <-sub.state.done:
+		return false
+	}
 }
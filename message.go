@@ -1,6 +1,8 @@
 package stomp
 
 import (
+	"errors"
+	"io"
 	"strconv"
 	"strings"
 )
@@ -23,6 +25,13 @@ type Frame struct {
 	Body []byte
 
 	ch chan error
+
+	// source and sourceSize provide the body of a frame sent by SendStream.
+	source     io.Reader
+	sourceSize int64
+
+	// stream reads the body of a streamed message.
+	stream *bodyStream
 }
 
 func (f *Frame) get(key string) string {
@@ -64,6 +73,16 @@ func (m *Error) Error() string {
 // messages from subscriptions to the client.
 type Message struct {
 	Frame
+
+	// acks are the ack IDs of the chunks of a reassembled message.
+	acks []string
+
+	// conn is the connection the message was received from.
+	conn *Conn
+
+	// mode is the ack mode of the subscription the message was received
+	// from.
+	mode AckMode
 }
 
 // Id returns the unique identifier for that message.
@@ -82,15 +101,37 @@ func (m *Message) Subscription() string {
 	return m.get("subscription")
 }
 
-// Ack returns the value of the "ack" header of a message. If the message is
+// AckID returns the value of the "ack" header of a message. If the message is
 // received from a subscription that requires explicit acknowledgment (either
 // client or client-individual mode) then the MESSAGE frame MUST also contain an
 // ack header with an arbitrary value. This header will be used to relate the
 // message to a subsequent ACK or NACK frame.
-func (m *Message) Ack() string {
+func (m *Message) AckID() string {
 	return m.get("ack")
 }
 
+// Ack acknowledges the message on the connection it was received from, see
+// Conn.Ack.
+func (m *Message) Ack(options ...Option) error {
+	if m.conn == nil {
+		return errNoConn
+	}
+	return m.conn.Ack(m, options...)
+}
+
+// Nack rejects the message on the connection it was received from, see
+// Conn.Nack.
+func (m *Message) Nack(options ...Option) error {
+	if m.conn == nil {
+		return errNoConn
+	}
+	return m.conn.Nack(m, options...)
+}
+
+// errNoConn is returned when acknowledging a message that was not received
+// from a connection.
+var errNoConn = errors.New("stomp: message not received from a connection")
+
 // ContentType returns the content type of the frame body if set by the  server.
 // If the content type is set, its value MUST be a MIME type which describes the
 // format of the body. Otherwise, the receiver SHOULD consider the body to be a
@@ -0,0 +1,160 @@
+package stomp
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Metrics receives measurements from a connection, see the stompprom
+// package for an implementation exporting them to Prometheus. The methods
+// are called synchronously by the goroutines serving the connection, so
+// they must be safe for concurrent use and must not block.
+type Metrics interface {
+	// FrameSent is called for each frame written to the server with its
+	// encoded size in octets. Heart-beats have an empty command.
+	FrameSent(command string, size int)
+
+	// FrameReceived is called for each frame read from the server with its
+	// encoded size in octets. Heart-beats have an empty command.
+	FrameReceived(command string, size int)
+
+	// SendLatency is called with the time it took to write a frame passed
+	// to a method of Conn, including the time waiting for other frames.
+	SendLatency(command string, d time.Duration)
+
+	// ReceiptLatency is called with the time between writing a frame with a
+	// receipt header and receiving the corresponding RECEIPT frame.
+	ReceiptLatency(d time.Duration)
+
+	// ReconnectAttempt is called for each attempt to reconnect.
+	ReconnectAttempt()
+
+	// HeartBeatMissed is called for each heart-beat from the server that
+	// did not arrive in time.
+	HeartBeatMissed()
+
+	// QueueDepth is called with the number of messages buffered for the
+	// subscription with the given ID to destination whenever a message is
+	// delivered to it and periodically while it is active.
+	QueueDepth(subscription, destination string, depth int)
+
+	// SubscriptionEnded is called when the subscription with the given ID
+	// ends. QueueDepth is not called for it afterwards.
+	SubscriptionEnded(subscription, destination string)
+
+	// MessageDropped is called for each message to destination that is
+	// dropped because its subscription no longer exists.
+	MessageDropped(destination string)
+}
+
+// nopMetrics is used if no Metrics are configured on the Dialer.
+type nopMetrics struct{}
+
+func (nopMetrics) FrameSent(string, int)             {}
+func (nopMetrics) FrameReceived(string, int)         {}
+func (nopMetrics) SendLatency(string, time.Duration) {}
+func (nopMetrics) ReceiptLatency(time.Duration)      {}
+func (nopMetrics) ReconnectAttempt()                 {}
+func (nopMetrics) HeartBeatMissed()                  {}
+func (nopMetrics) QueueDepth(string, string, int)    {}
+func (nopMetrics) SubscriptionEnded(string, string)  {}
+func (nopMetrics) MessageDropped(string)             {}
+
+// queueDepthInterval is the interval in which the queue depth of active
+// subscriptions is reported.
+const queueDepthInterval = time.Second
+
+// reportQueueDepth reports the queue depth of sub periodically until it
+// ends, as messages taken from its channel are not observed otherwise.
+func (c *Conn) reportQueueDepth(sub *Subscription) {
+	ticker := time.NewTicker(queueDepthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sub.state.done:
+			return
+		case <-ticker.C:
+		}
+
+		// the depth is reported under the lock of the subscription, so
+		// it is not reported after SubscriptionEnded
+		sub.state.mu.Lock()
+		select {
+		case <-sub.state.done:
+		default:
+			c.metrics.QueueDepth(sub.id, sub.destination, len(sub.C))
+		}
+		sub.state.mu.Unlock()
+	}
+}
+
+// errReceiptLost is reported to receipt waiters when the connection is lost.
+var errReceiptLost = errors.New("stomp: connection lost before receipt")
+
+// receiptTracker remembers when frames requesting a receipt were sent and
+// notifies callers waiting for the receipt.
+type receiptTracker struct {
+	mu      sync.Mutex
+	pending map[string]time.Time
+	waiters map[string]chan error
+}
+
+// wait returns a channel receiving nil when the receipt id arrives, or an
+// error if the connection is lost first. It must be called before the frame
+// requesting the receipt is written.
+func (r *receiptTracker) wait(id string) <-chan error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.waiters == nil {
+		r.waiters = make(map[string]chan error)
+	}
+	ch := make(chan error, 1)
+	r.waiters[id] = ch
+	return ch
+}
+
+// cancel stops waiting for the receipt id.
+func (r *receiptTracker) cancel(id string) {
+	r.mu.Lock()
+	delete(r.waiters, id)
+	r.mu.Unlock()
+}
+
+func (r *receiptTracker) sent(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending == nil {
+		r.pending = make(map[string]time.Time)
+	}
+	r.pending[id] = time.Now()
+}
+
+// received returns the time since the frame with receipt id was sent.
+func (r *receiptTracker) received(id string) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ch, ok := r.waiters[id]; ok {
+		ch <- nil
+		delete(r.waiters, id)
+	}
+
+	t, ok := r.pending[id]
+	if !ok {
+		return 0, false
+	}
+	delete(r.pending, id)
+	return time.Since(t), true
+}
+
+// reset forgets all pending receipts, they are lost with the connection.
+func (r *receiptTracker) reset() {
+	r.mu.Lock()
+	r.pending = nil
+	for _, ch := range r.waiters {
+		ch <- errReceiptLost
+	}
+	r.waiters = nil
+	r.mu.Unlock()
+}
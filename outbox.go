@@ -0,0 +1,408 @@
+package stomp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultOutboxReceiptTimeout is how long the forwarder of an Outbox
+	// waits for the receipt of a message unless configured otherwise.
+	DefaultOutboxReceiptTimeout = 30 * time.Second
+
+	// DefaultOutboxRetryInterval is how long the forwarder of an Outbox
+	// waits before sending a message again after a failure unless
+	// configured otherwise.
+	DefaultOutboxRetryInterval = time.Second
+
+	// outboxCompactThreshold is the minimum number of deleted entries in
+	// the log before it is compacted.
+	outboxCompactThreshold = 1024
+
+	// outboxIDHeader identifies the entries in the log.
+	outboxIDHeader = "outbox-id"
+)
+
+// A SyncPolicy determines when an Outbox commits its log to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncAlways commits every message to stable storage before Send
+	// returns. No message is lost on power failure, at the expense of
+	// throughput.
+	SyncAlways SyncPolicy = iota
+
+	// SyncInterval commits the log at most once per SyncInterval when
+	// messages are appended. Messages appended since the last commit may
+	// be lost on power failure, but not if only the process crashes.
+	SyncInterval
+
+	// SyncNever leaves committing the log to the operating system.
+	SyncNever
+)
+
+// An Outbox is a durable queue of messages waiting to be sent. Messages are
+// appended to a log file, so they survive restarts of the process, and are
+// forwarded to the server by a connection dialed with the outbox, see
+// Dialer.Outbox. A message is removed from the log only after the server
+// acknowledged it with a RECEIPT frame. As messages are sent again if the
+// receipt is lost, they are delivered at least once.
+//
+// Messages are forwarded one at a time in the order they were sent. An
+// Outbox must only be used by one connection at a time.
+type Outbox struct {
+	// Sync determines when the log is committed to stable storage. Removed
+	// messages are never committed explicitly, they may be sent again
+	// after a power failure.
+	Sync SyncPolicy
+
+	// SyncInterval is the interval for the SyncInterval policy. If zero,
+	// the log is committed at most once per second.
+	SyncInterval time.Duration
+
+	// ReceiptTimeout is how long to wait for the receipt of a forwarded
+	// message before sending it again. If zero,
+	// DefaultOutboxReceiptTimeout is used.
+	ReceiptTimeout time.Duration
+
+	// RetryInterval is how long to wait before sending a message again
+	// after sending it failed. If zero, DefaultOutboxRetryInterval is used.
+	RetryInterval time.Duration
+
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	encoder  *Encoder
+	entries  []outboxEntry
+	next     uint64
+	dead     int
+	synced   time.Time
+	closed   bool
+	appended chan struct{}
+}
+
+// outboxEntry is a message waiting in an Outbox.
+type outboxEntry struct {
+	id    uint64
+	frame *Frame
+}
+
+// OpenOutbox opens the outbox with its log file in dir. The directory is
+// created if it does not exist. Messages left in the log by a previous
+// process are forwarded again. An incomplete record at the end of the log,
+// as left behind by a crash, is discarded. A log that is corrupt elsewhere
+// is left untouched and reported as an error.
+func OpenOutbox(dir string) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	o := &Outbox{
+		path:     filepath.Join(dir, "outbox.log"),
+		appended: make(chan struct{}),
+	}
+
+	entries, err := o.read()
+	if err != nil {
+		return nil, err
+	}
+	o.entries = entries
+	if len(entries) > 0 {
+		o.next = entries[len(entries)-1].id + 1
+	}
+
+	// compaction rewrites the log without deleted and incomplete entries
+	if err := o.compact(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Send appends a message to the outbox. The arguments are the same as for
+// Conn.Send. The options are applied immediately.
+func (o *Outbox) Send(destination, contentType string, body []byte, options ...Option) error {
+	frame := &Frame{
+		Command: "SEND",
+		Header: Header{
+			"destination":  destination,
+			"content-type": contentType,
+		},
+		Body: body,
+	}
+	if len(body) > 0 {
+		frame.Header["content-length"] = strconv.Itoa(len(body))
+	}
+	for _, fn := range options {
+		fn(frame)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return errors.New("stomp: outbox closed")
+	}
+
+	entry := outboxEntry{id: o.next, frame: frame}
+	if err := o.write(entry); err != nil {
+		return err
+	}
+	// the record is in the log even if it cannot be synced, its ID must
+	// not be reused
+	o.next++
+
+	if o.Sync == SyncAlways || o.Sync == SyncInterval && time.Since(o.synced) >= o.syncInterval() {
+		if err := o.file.Sync(); err != nil {
+			return err
+		}
+		o.synced = time.Now()
+	}
+
+	o.entries = append(o.entries, entry)
+	close(o.appended)
+	o.appended = make(chan struct{})
+	return nil
+}
+
+// Len returns the number of messages waiting in the outbox.
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+// Close commits and closes the log. Messages still waiting are forwarded
+// when the outbox is opened again.
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return nil
+	}
+	o.closed = true
+
+	if err := o.file.Sync(); err != nil {
+		o.file.Close()
+		return err
+	}
+	return o.file.Close()
+}
+
+func (o *Outbox) syncInterval() time.Duration {
+	if o.SyncInterval > 0 {
+		return o.SyncInterval
+	}
+	return time.Second
+}
+
+// peek returns the oldest waiting message and a channel closed when a
+// message is appended.
+func (o *Outbox) peek() (outboxEntry, bool, <-chan struct{}) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.entries) == 0 || o.closed {
+		return outboxEntry{}, false, o.appended
+	}
+	return o.entries[0], true, o.appended
+}
+
+// remove removes the oldest message, which has been forwarded.
+func (o *Outbox) remove(id uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed || len(o.entries) == 0 || o.entries[0].id != id {
+		return nil
+	}
+
+	record := &Frame{
+		Command: "DELETE",
+		Header:  Header{outboxIDHeader: strconv.FormatUint(id, 10)},
+	}
+	if err := o.encoder.Encode(record); err != nil {
+		return err
+	}
+
+	o.entries = o.entries[1:]
+	o.dead++
+	if o.dead >= outboxCompactThreshold && o.dead > len(o.entries) {
+		return o.compact()
+	}
+	return nil
+}
+
+// write appends an entry to the log. The caller must hold o.mu.
+func (o *Outbox) write(entry outboxEntry) error {
+	record := &Frame{
+		Command: entry.frame.Command,
+		Header:  maps.Clone(entry.frame.Header),
+		Body:    entry.frame.Body,
+	}
+	record.Header[outboxIDHeader] = strconv.FormatUint(entry.id, 10)
+	record.Header["content-length"] = strconv.Itoa(len(record.Body))
+	return o.encoder.Encode(record)
+}
+
+// read returns the entries in the log that have not been deleted.
+func (o *Outbox) read() ([]outboxEntry, error) {
+	file, err := os.Open(o.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []outboxEntry
+	deleted := make(map[uint64]bool)
+	decoder := NewDecoder(file)
+	for {
+		record, err := decoder.Decode()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// end of the log or a torn write at its end
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("stomp: corrupt outbox log %s: %w", o.path, err)
+		}
+
+		id, err := strconv.ParseUint(record.Header[outboxIDHeader], 10, 64)
+		if err != nil {
+			continue
+		}
+		delete(record.Header, outboxIDHeader)
+
+		switch record.Command {
+		case "SEND":
+			entries = append(entries, outboxEntry{id: id, frame: record})
+		case "DELETE":
+			deleted[id] = true
+		}
+	}
+
+	live := entries[:0]
+	for _, entry := range entries {
+		if !deleted[entry.id] {
+			live = append(live, entry)
+		}
+	}
+	return live, nil
+}
+
+// compact rewrites the log so that it only contains the waiting entries and
+// opens it for appending. The caller must hold o.mu, if the outbox is in
+// use.
+func (o *Outbox) compact() error {
+	if o.file != nil {
+		o.file.Close()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(o.path), "compact-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	o.encoder = NewEncoder(tmp)
+	for _, entry := range o.entries {
+		if err := o.write(entry); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), o.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	o.file = file
+	o.encoder = NewEncoder(file)
+	o.dead = 0
+	o.synced = time.Now()
+	return nil
+}
+
+// forward sends the messages of o over c until stop is closed.
+func (o *Outbox) forward(c *Conn, stop <-chan struct{}) {
+	receiptTimeout := o.ReceiptTimeout
+	if receiptTimeout <= 0 {
+		receiptTimeout = DefaultOutboxReceiptTimeout
+	}
+	retryInterval := o.RetryInterval
+	if retryInterval <= 0 {
+		retryInterval = DefaultOutboxRetryInterval
+	}
+
+	for {
+		entry, ok, appended := o.peek()
+		if !ok {
+			select {
+			case <-stop:
+				return
+			case <-appended:
+				continue
+			}
+		}
+
+		err := c.sendReceipted(entry.frame, receiptTimeout, stop)
+		if err == nil {
+			err = o.remove(entry.id)
+		}
+		if err == nil {
+			continue
+		}
+
+		c.logger.Warn("stomp: forwarding outbox message failed",
+			"destination", entry.frame.Header["destination"], "err", err)
+		select {
+		case <-stop:
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// sendReceipted sends a copy of f with a receipt header and waits for the
+// receipt.
+func (c *Conn) sendReceipted(f *Frame, timeout time.Duration, stop <-chan struct{}) error {
+	id := "outbox-" + randID()
+	done := c.receipts.wait(id)
+
+	frame := &Frame{
+		Command: f.Command,
+		Header:  maps.Clone(f.Header),
+		Body:    f.Body,
+	}
+	frame.Header["receipt"] = id
+
+	if err := c.safeWrite(frame); err != nil {
+		c.receipts.cancel(id)
+		return err
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		c.receipts.cancel(id)
+		return errors.New("stomp: timeout waiting for receipt")
+	case <-stop:
+		c.receipts.cancel(id)
+		return ErrClosed
+	}
+}
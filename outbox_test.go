@@ -0,0 +1,143 @@
+package stomp_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"github.com/cumulodev/stomp/stomptest"
+)
+
+func TestOutbox(t *testing.T) {
+	dir := t.TempDir()
+
+	// messages are kept while the broker is unreachable
+	outbox, err := stomp.OpenOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"one", "two"} {
+		if err := outbox.Send("/queue/test", "text/plain", []byte(body), stomp.Persist()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := outbox.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// and forwarded after a restart
+	outbox, err = stomp.OpenOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outbox.Close()
+	outbox.RetryInterval = 10 * time.Millisecond
+	if n := outbox.Len(); n != 2 {
+		t.Fatalf("got %d messages after reopening, want 2", n)
+	}
+
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	// the first attempt fails without receipt
+	s.FailNext("SEND", "broker busy")
+
+	r := newReconnects(10)
+	d := r.dialer(nil)
+	d.Outbox = outbox
+	conn, err := d.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.Send("/queue/test", "text/plain", []byte("three")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SendValue("/queue/test", "four"); err != nil {
+		t.Fatal(err)
+	}
+	r.wait(t)
+
+	sub, err := conn.Subscribe("/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"one", "two", "three", `"four"`} {
+		msg := receive(t, sub)
+		if string(msg.Body) != want || msg.Header["receipt"] != "" {
+			t.Errorf("got message %q %v, want %q", msg.Body, msg.Header, want)
+		}
+		if (want == "one" || want == "two") && msg.Header["persistent"] != "true" {
+			t.Errorf("got message %v, want options applied", msg.Header)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for outbox.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := outbox.Len(); n != 0 {
+		t.Errorf("got %d messages after forwarding, want none", n)
+	}
+
+	// forwarded messages are not sent again
+	outbox.Close()
+	outbox, err = stomp.OpenOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outbox.Close()
+	if n := outbox.Len(); n != 0 {
+		t.Errorf("got %d messages after reopening, want none", n)
+	}
+}
+
+func TestOutboxCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	outbox, err := stomp.OpenOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"one", "two", "three"} {
+		if err := outbox.Send("/queue/test", "text/plain", []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	outbox.Close()
+
+	name := filepath.Join(dir, "outbox.log")
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a torn write at the end of the log is discarded
+	if err := os.WriteFile(name, data[:len(data)-3], 0644); err != nil {
+		t.Fatal(err)
+	}
+	outbox, err = stomp.OpenOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := outbox.Len(); n != 2 {
+		t.Errorf("got %d messages after torn write, want 2", n)
+	}
+	outbox.Close()
+
+	// a corrupt record in the middle fails without losing the messages
+	// after it
+	corrupt := bytes.Replace(data, []byte("one\x00"), []byte("oneX"), 1)
+	if err := os.WriteFile(name, corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stomp.OpenOutbox(dir); err == nil {
+		t.Error("opened corrupt outbox")
+	}
+	if got, err := os.ReadFile(name); err != nil || !bytes.Equal(got, corrupt) {
+		t.Errorf("corrupt log changed by OpenOutbox: %v", err)
+	}
+}
@@ -1,6 +1,12 @@
 package stomp
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -9,52 +15,66 @@ var newline = []byte{}
 var null = []byte{0x0}
 var heartbeat = []byte{'\n'}
 
-func (c *Conn) safeRead() chan *Frame {
-	ch := make(chan *Frame, 1)
+// A Decoder reads and decodes STOMP frames from an input stream.
+//
+// By default the decoder is lenient and accepts frames violating the
+// specification where their meaning is unambiguous: header lines without
+// a colon are skipped, undefined escape sequences are kept literally and an
+// invalid content-length header is ignored. In strict mode, these are
+// reported as protocol errors. A frame body that is not terminated by a NULL
+// octet is always a protocol error.
+type Decoder struct {
+	// Strict enables strict parsing of frames.
+	Strict bool
 
-	go func() {
-		select {
-		case <-c.closeC:
-			return
-
-		case c.readC <- 1:
-			f, err := c.unsafeRead()
-			if err != nil {
-				c.error(err)
-				return
-			}
-
-			<-c.readC
-			ch <- f
-		}
-	}()
+	reader *bufio.Reader
+	n      int64 // octets consumed
 
-	return ch
+	// stream is the content-length from which message bodies are not read
+	// by Decode but streamed, see Message.BodyReader. Zero disables
+	// streaming.
+	stream int64
 }
 
-// unsafeRead reads the next frame. This function is not thread safe!
-func (c *Conn) unsafeRead() (*Frame, error) {
-	if c.rhb > 0 {
-		c.conn.SetReadDeadline(time.Now().Add(2 * c.rhb))
-	} else {
-		c.conn.SetReadDeadline(time.Time{})
+// NewDecoder returns a new decoder that reads from r. If r is not already
+// a *bufio.Reader, the decoder introduces its own buffering and may read
+// data from r beyond the frames requested.
+func NewDecoder(r io.Reader) *Decoder {
+	reader, ok := r.(*bufio.Reader)
+	if !ok {
+		reader = bufio.NewReader(r)
 	}
 
+	return &Decoder{reader: reader}
+}
+
+// Decode reads the next frame from its input. A received heart-beat is
+// returned as an empty frame without a command.
+func (d *Decoder) Decode() (*Frame, error) {
 	// get stomp command
-	command, err := c.readLine()
+	command, err := d.readLine()
 	if err != nil {
 		return nil, err
 	}
 
+	if strings.HasSuffix(command, "\r") {
+		if d.Strict {
+			return nil, &ProtocolError{Line: command, Msg: "invalid command"}
+		}
+		command = strings.TrimRight(command, "\r")
+	}
+
 	if len(command) == 0 {
 		// received heartbeat, return empty frame
 		return &Frame{}, nil
 	}
 
-	// get stomp headers
+	// get stomp headers, CONNECT and CONNECTED frames do not escape header
+	// values for backward compatibility with STOMP 1.0
+	escaped := command != "CONNECT" && command != "CONNECTED" && command != "STOMP"
 	header := make(Header)
 	for {
-		line, err := c.readLine()
+		line, err := d.readLine()
 		if err != nil {
 			return nil, err
 		}
@@ -63,12 +83,38 @@ func (c *Conn) unsafeRead() (*Frame, error) {
 			break
 		}
 
-		key, value := decodeHeader(line)
-		header[key] = value
+		key, value, err := d.decodeHeader(line, escaped)
+		if err == errSkipHeader {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		// if a header is repeated, only the first entry is used
+		if _, ok := header[key]; !ok {
+			header[key] = value
+		}
+	}
+
+	if d.Strict {
+		if err := checkHeader(command, header); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.stream > 0 && command == "MESSAGE" {
+		length, err := strconv.ParseInt(header["content-length"], 10, 64)
+		if err == nil && length >= d.stream {
+			return &Frame{
+				Command: command,
+				Header:  header,
+				stream:  newBodyStream(d, length),
+			}, nil
+		}
 	}
 
 	// get stomp body
-	body, err := c.readBody()
+	body, err := d.readBody(header)
 	if err != nil {
 		return nil, err
 	}
@@ -80,8 +126,76 @@ func (c *Conn) unsafeRead() (*Frame, error) {
 	}, nil
 }
 
-func (c *Conn) readLine() (string, error) {
-	line, err := c.reader.ReadString('\n')
+// readFrames reads frames from the current network connection and sends them
+// to frames until closeC is closed. The first read error is sent to errC.
+func (c *Conn) readFrames(closeC chan struct{}, frames chan *Frame, errC chan error) {
+	defer c.loops.Done()
+
+	for {
+		f, err := c.unsafeRead()
+		if err != nil {
+			errC <- err
+			return
+		}
+
+		if f.stream != nil {
+			c.streaming.Store(true)
+		}
+
+		select {
+		case <-closeC:
+			return
+		case frames <- f:
+		}
+
+		if f.stream != nil {
+			// the body must be consumed before the next frame is read
+			select {
+			case <-closeC:
+				return
+			case <-f.stream.done:
+			}
+
+			c.streaming.Store(false)
+			if f.stream.err != nil {
+				errC <- f.stream.err
+				return
+			}
+		}
+	}
+}
+
+// unsafeRead reads the next frame. This function is not thread safe!
+func (c *Conn) unsafeRead() (*Frame, error) {
+	conn := c.conn
+	deadline := func() {
+		if timeout := c.heartbeat.readTimeout(); timeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(timeout))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+	}
+	deadline()
+
+	n := c.decoder.n
+	f, err := c.decoder.Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	size := c.decoder.n - n
+	if f.stream != nil {
+		// the body is read later by the consumer
+		f.stream.refresh = deadline
+		size += f.stream.remaining + 1
+	}
+	c.metrics.FrameReceived(f.Command, int(size))
+	return f, nil
+}
+
+func (d *Decoder) readLine() (string, error) {
+	line, err := d.reader.ReadString('\n')
+	d.n += int64(len(line))
 	if err != nil {
 		return "", err
 	}
@@ -97,8 +211,36 @@ func (c *Conn) readLine() (string, error) {
 	return line, nil
 }
 
-func (c *Conn) readBody() ([]byte, error) {
-	data, err := c.reader.ReadBytes('\x00')
+func (d *Decoder) readBody(header Header) ([]byte, error) {
+	if value, ok := header["content-length"]; ok {
+		length, err := strconv.ParseInt(value, 10, 64)
+		if err == nil && length >= 0 {
+			// read exactly length octets followed by the NULL octet. The
+			// buffer grows with the received data instead of trusting
+			// the announced length.
+			var buf bytes.Buffer
+			n, err := io.Copy(&buf, io.LimitReader(d.reader, length))
+			d.n += n
+			if err != nil {
+				return nil, err
+			} else if n < length {
+				return nil, io.ErrUnexpectedEOF
+			}
+
+			if err := d.readNull(); err != nil {
+				return nil, err
+			}
+
+			return buf.Bytes(), nil
+		}
+
+		if d.Strict {
+			return nil, &ProtocolError{Line: "content-length:" + value, Msg: "invalid content-length"}
+		}
+	}
+
+	data, err := d.reader.ReadBytes('\x00')
+	d.n += int64(len(data))
 	if err != nil {
 		return nil, err
 	}
@@ -111,15 +253,132 @@ func (c *Conn) readBody() ([]byte, error) {
 	return data, nil
 }
 
-func decodeHeader(header string) (string, string) {
-	decode := func(v string) string {
-		v = strings.Replace(v, "\\r", "\r", -1)
-		v = strings.Replace(v, "\\n", "\n", -1)
-		v = strings.Replace(v, "\\c", ":", -1)
-		v = strings.Replace(v, "\\\\", "\\", -1)
-		return v
+// readNull reads the NULL octet terminating a frame body.
+func (d *Decoder) readNull() error {
+	null, err := d.reader.ReadByte()
+	if err == nil {
+		d.n++
+	}
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return err
+	}
+
+	if null != '\x00' {
+		return &ProtocolError{Msg: "frame body not terminated by NULL octet"}
+	}
+	return nil
+}
+
+// requiredHeaders lists the headers each frame MUST contain by command. It
+// also serves as the list of commands known to a strict decoder.
+var requiredHeaders = map[string][]string{
+	// client frames
+	"CONNECT":     {"accept-version", "host"},
+	"STOMP":       {"accept-version", "host"},
+	"SEND":        {"destination"},
+	"SUBSCRIBE":   {"destination", "id"},
+	"UNSUBSCRIBE": {"id"},
+	"ACK":         {"id"},
+	"NACK":        {"id"},
+	"BEGIN":       {"transaction"},
+	"COMMIT":      {"transaction"},
+	"ABORT":       {"transaction"},
+	"DISCONNECT":  nil,
+
+	// server frames
+	"CONNECTED": {"version"},
+	"MESSAGE":   {"destination", "message-id", "subscription"},
+	"RECEIPT":   {"receipt-id"},
+	"ERROR":     nil,
+}
+
+// checkHeader verifies that command is known and the header contains all
+// entries required for it.
+func checkHeader(command string, header Header) error {
+	required, ok := requiredHeaders[command]
+	if !ok {
+		return &ProtocolError{Line: command, Msg: "unknown command"}
+	}
+
+	for _, key := range required {
+		if _, ok := header[key]; !ok {
+			return &ProtocolError{Line: command, Msg: fmt.Sprintf("missing %s header", key)}
+		}
+	}
+	return nil
+}
+
+// errSkipHeader is returned by decodeHeader for malformed header lines that
+// are ignored by a lenient decoder.
+var errSkipHeader = errors.New("skip header")
+
+func (d *Decoder) decodeHeader(line string, escaped bool) (string, string, error) {
+	i := strings.IndexByte(line, ':')
+	if i < 0 {
+		if d.Strict {
+			return "", "", &ProtocolError{Line: line, Msg: "header without colon"}
+		}
+		return "", "", errSkipHeader
+	}
+
+	if !escaped {
+		return line[:i], line[i+1:], nil
+	}
+
+	key, err := d.unescape(line, line[:i])
+	if err != nil {
+		return "", "", err
+	}
+
+	value, err := d.unescape(line, line[i+1:])
+	if err != nil {
+		return "", "", err
+	}
+
+	return key, value, nil
+}
+
+// unescape decodes the escape sequences of a header key or value v, which is
+// part of line.
+func (d *Decoder) unescape(line, v string) (string, error) {
+	if strings.IndexByte(v, '\\') < 0 {
+		return v, nil
+	}
+
+	buf := make([]byte, 0, len(v))
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' {
+			buf = append(buf, v[i])
+			continue
+		}
+
+		var next byte
+		if i+1 < len(v) {
+			next = v[i+1]
+		}
+
+		switch next {
+		case 'r':
+			buf = append(buf, '\r')
+		case 'n':
+			buf = append(buf, '\n')
+		case 'c':
+			buf = append(buf, ':')
+		case '\\':
+			buf = append(buf, '\\')
+		default:
+			if d.Strict {
+				return "", &ProtocolError{Line: line, Msg: "undefined escape sequence"}
+			}
+
+			// keep the backslash and decode the next octet normally
+			buf = append(buf, '\\')
+			continue
+		}
+		i++
 	}
 
-	kv := strings.SplitN(header, ":", 2)
-	return decode(kv[0]), decode(kv[1])
+	return string(buf), nil
 }
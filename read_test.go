@@ -0,0 +1,104 @@
+package stomp
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		lenient Header
+		line    string
+	}{
+		{"header without colon", "SEND\ndestination\n\n\x00", Header{}, "destination"},
+		{"undefined escape", "SEND\nkey:a\\tb\n\n\x00", Header{"key": "a\\tb"}, "key:a\\tb"},
+		{"trailing backslash", "SEND\nkey:a\\\n\n\x00", Header{"key": "a\\"}, "key:a\\"},
+		{"invalid content-length", "SEND\ndestination:a\ncontent-length:x\n\n\x00", Header{"destination": "a", "content-length": "x"}, "content-length:x"},
+		{"unknown command", "FOO\n\n\x00", Header{}, "FOO"},
+		{"missing header", "SEND\nkey:value\n\n\x00", Header{"key": "value"}, "SEND"},
+	}
+
+	for _, test := range tests {
+		lenient := NewDecoder(strings.NewReader(test.input))
+		f, err := lenient.Decode()
+		if err != nil {
+			t.Errorf("%s: lenient decoding failed: %v", test.name, err)
+		} else if !reflect.DeepEqual(f.Header, test.lenient) {
+			t.Errorf("%s: got header %v, want %v", test.name, f.Header, test.lenient)
+		}
+
+		strict := NewDecoder(strings.NewReader(test.input))
+		strict.Strict = true
+		_, err = strict.Decode()
+
+		var perr *ProtocolError
+		if !errors.Is(err, ErrProtocol) || !errors.As(err, &perr) {
+			t.Errorf("%s: got error %v, want protocol error", test.name, err)
+		} else if perr.Line != test.line {
+			t.Errorf("%s: got offending line %q, want %q", test.name, perr.Line, test.line)
+		}
+	}
+}
+
+func TestDecodeUnterminatedBody(t *testing.T) {
+	d := NewDecoder(strings.NewReader("SEND\ncontent-length:1\n\nab\x00"))
+	if _, err := d.Decode(); !errors.Is(err, ErrProtocol) {
+		t.Errorf("got error %v, want protocol error", err)
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add([]byte("CONNECTED\nversion:1.2\nheart-beat:0,0\n\n\x00\n"))
+	f.Add([]byte("MESSAGE\r\nsubscription:0\r\nmessage-id:1\r\ndestination:/queue/a\\cb\r\n\r\nhello\x00"))
+	f.Add([]byte("MESSAGE\ncontent-length:5\n\nab\x00cd\x00"))
+	f.Add([]byte("ERROR\nmessage\n\n\x00"))
+	f.Add([]byte("\n\n\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, strict := range []bool{false, true} {
+			d := NewDecoder(bytes.NewReader(data))
+			d.Strict = strict
+
+			frame, err := d.Decode()
+			if err != nil || !encodable(frame) {
+				continue
+			}
+
+			// a decoded frame survives another round trip unchanged
+			again, err := NewDecoder(bytes.NewReader(encodeFrame(frame))).Decode()
+			if err != nil {
+				t.Fatalf("decoding re-encoded frame %+v: %v", frame, err)
+			}
+			if !equalFrames(frame, again) {
+				t.Fatalf("round trip changed frame %+v to %+v", frame, again)
+			}
+		}
+	})
+}
+
+// encodable reports whether the headers of f can be encoded without loss.
+// CONNECT and CONNECTED frames cannot carry CR or LF octets in their headers
+// as they are not escaped.
+func encodable(f *Frame) bool {
+	if f.Command != "CONNECT" && f.Command != "CONNECTED" && f.Command != "STOMP" {
+		return true
+	}
+
+	for key, value := range f.Header {
+		if strings.ContainsAny(key, ":\r\n") || strings.ContainsAny(value, "\r\n") {
+			return false
+		}
+	}
+	return true
+}
+
+func equalFrames(a, b *Frame) bool {
+	return a.Command == b.Command && len(a.Header) == len(b.Header) &&
+		(len(a.Header) == 0 || reflect.DeepEqual(a.Header, b.Header)) &&
+		bytes.Equal(a.Body, b.Body)
+}
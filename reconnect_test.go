@@ -0,0 +1,286 @@
+package stomp_test
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"github.com/cumulodev/stomp/stomptest"
+)
+
+// reconnects records the errors passed to the Reconnect function of a Conn
+// and allows a limited number of fast reconnect attempts.
+type reconnects struct {
+	errs    chan error
+	success chan int
+	max     int
+}
+
+func newReconnects(max int) *reconnects {
+	return &reconnects{
+		errs:    make(chan error, 100),
+		success: make(chan int, 100),
+		max:     max,
+	}
+}
+
+func (r *reconnects) dialer(netDial func(network, addr string) (net.Conn, error)) *stomp.Dialer {
+	return &stomp.Dialer{
+		NetDial: netDial,
+		Reconnect: func(n int, d time.Duration, err error) (bool, time.Duration) {
+			r.errs <- err
+			return n <= r.max, 10 * time.Millisecond
+		},
+		ReconnectSuccess: func(n int) {
+			r.success <- n
+		},
+	}
+}
+
+func (r *reconnects) err(t *testing.T) error {
+	t.Helper()
+	select {
+	case err := <-r.errs:
+		return err
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for reconnect attempt")
+		return nil
+	}
+}
+
+func (r *reconnects) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-r.success:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for reconnect")
+	}
+}
+
+func receive(t *testing.T, sub *stomp.Subscription) *stomp.Message {
+	t.Helper()
+	select {
+	case msg, ok := <-sub.C:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for message")
+		return nil
+	}
+}
+
+func TestReconnectPartialWrite(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	dialer := stomptest.NewFaultDialer()
+	r := newReconnects(3)
+	conn, err := r.dialer(dialer.Dial).Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.Subscribe("/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cut the next frame in half
+	dialer.Conns()[0].Inject(stomptest.ResetAfter(stomptest.Write, 5))
+	if err := conn.Send("/queue/test", "text/plain", []byte("lost")); !errors.Is(err, stomptest.ErrInjected) {
+		t.Errorf("got Send error %v, want %v", err, stomptest.ErrInjected)
+	}
+
+	if err := r.err(t); err == nil {
+		t.Error("reconnect without cause")
+	}
+	r.wait(t)
+
+	if err := conn.Send("/queue/test", "text/plain", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, sub); string(msg.Body) != "hello" {
+		t.Errorf("got message %q, want %q", msg.Body, "hello")
+	}
+
+	// the subscription is restored with the same id on the new connection
+	subs, err := s.WaitFrames("SUBSCRIBE", 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subs[0].Header["id"] != subs[1].Header["id"] || subs[1].Header["destination"] != "/queue/test" {
+		t.Errorf("got resubscription %v, want %v", subs[1].Header, subs[0].Header)
+	}
+}
+
+func TestReconnectServerReset(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	r := newReconnects(3)
+	conn, err := r.dialer(nil).Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.Subscribe("/queue/test", stomp.Ack(stomp.AckClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.WaitFrames("SUBSCRIBE", 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	s.DropConnections()
+	r.err(t)
+	r.wait(t)
+
+	s.Publish("/queue/test", []byte("hello"), nil)
+	if msg := receive(t, sub); string(msg.Body) != "hello" {
+		t.Errorf("got message %q, want %q", msg.Body, "hello")
+	}
+
+	// the subscription options are applied again on resubscribe
+	subs, err := s.WaitFrames("SUBSCRIBE", 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack := subs[1].Header["ack"]; ack != string(stomp.AckClient) {
+		t.Errorf("got ack mode %q on resubscribe, want %q", ack, stomp.AckClient)
+	}
+}
+
+func TestReconnectStalledRead(t *testing.T) {
+	s := stomptest.NewUnstartedServer()
+	s.SendHeartBeat = 20 * time.Millisecond
+	s.Start()
+	defer s.Close()
+
+	dialer := stomptest.NewFaultDialer()
+	r := newReconnects(3)
+	conn, err := r.dialer(dialer.Dial).Dial("tcp", s.Addr, stomp.Heartbeat(0, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// heart-beats of the server no longer arrive
+	dialer.Conns()[0].Inject(stomptest.StallAfter(stomptest.Read, 0))
+	if err := r.err(t); err == nil || !strings.Contains(err.Error(), "heartbeat") && !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("got reconnect cause %v, want missing heart-beat", err)
+	}
+	r.wait(t)
+}
+
+func TestReconnectDelayedHeartBeat(t *testing.T) {
+	s := stomptest.NewUnstartedServer()
+	s.SendHeartBeat = 50 * time.Millisecond
+	s.Start()
+	defer s.Close()
+
+	dialer := stomptest.NewFaultDialer()
+	r := newReconnects(3)
+	conn, err := r.dialer(dialer.Dial).Dial("tcp", s.Addr, stomp.Heartbeat(0, 50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// a late heart-beat within the tolerance does not break the connection
+	dialer.Conns()[0].Inject(stomptest.DelayAfter(stomptest.Read, 0, 20*time.Millisecond))
+	select {
+	case err := <-r.errs:
+		t.Errorf("reconnected after delayed heart-beat: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestCorruptConnected(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	dialer := stomptest.NewFaultDialer([]stomptest.Fault{stomptest.CorruptAt(stomptest.Read, 0)})
+	_, err := (&stomp.Dialer{NetDial: dialer.Dial}).Dial("tcp", s.Addr)
+	if err == nil {
+		t.Fatal("connected despite corrupted CONNECTED frame")
+	}
+}
+
+func TestErrorFrame(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	r := newReconnects(0)
+	conn, err := r.dialer(nil).Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.Subscribe("/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.SendError("shutting down")
+	if err, ok := r.err(t).(*stomp.Error); !ok || err.Error() != "shutting down" {
+		t.Errorf("got reconnect cause %v, want ERROR frame", err)
+	}
+
+	// giving up closes the subscriptions and reports the error
+	select {
+	case _, ok := <-sub.C:
+		if ok {
+			t.Fatal("received message, want closed subscription")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscription not closed")
+	}
+
+	if err, ok := conn.Err.(*stomp.Error); !ok || err.Error() != "shutting down" {
+		t.Errorf("got Err %v, want ERROR frame", conn.Err)
+	}
+
+	if err := conn.Send("/queue/test", "text/plain", nil); err == nil {
+		t.Error("Send succeeded on a closed connection")
+	}
+}
+
+func TestCloseWhileReconnecting(t *testing.T) {
+	s := stomptest.NewServer()
+
+	r := newReconnects(100)
+	conn, err := r.dialer(nil).Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the server is gone, so every reconnect attempt fails
+	s.Close()
+	r.err(t)
+	r.err(t)
+
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// drain attempts that were already running
+	time.Sleep(50 * time.Millisecond)
+	for len(r.errs) > 0 {
+		<-r.errs
+	}
+
+	select {
+	case err := <-r.errs:
+		t.Errorf("reconnect attempt after Close: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
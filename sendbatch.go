@@ -0,0 +1,239 @@
+package stomp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// DefaultReceiptTimeout is how long SendBatch waits for receipts unless
+// configured otherwise.
+const DefaultReceiptTimeout = 30 * time.Second
+
+// An Outgoing is a message sent with SendBatch.
+type Outgoing struct {
+	Destination string
+	ContentType string
+	Body        []byte
+	Options     []Option
+}
+
+// A Batch configures how SendBatch sends messages. The zero value sends the
+// messages without transaction and receipts.
+type Batch struct {
+	// Transaction sends the messages within a transaction, so the server
+	// delivers either all or none of them.
+	Transaction bool
+
+	// Receipts requests a receipt for each message and waits for them, so
+	// failures are reported per message.
+	Receipts bool
+
+	// ReceiptTimeout is how long to wait for the receipts. If zero,
+	// DefaultReceiptTimeout is used.
+	ReceiptTimeout time.Duration
+}
+
+// A BatchError reports the messages of a batch that were not confirmed by a
+// receipt.
+type BatchError struct {
+	// Errs has an entry for each message of the batch, which is nil if
+	// the message was sent successfully.
+	Errs []error
+}
+
+func (e *BatchError) Error() string {
+	var first error
+	n := 0
+	for _, err := range e.Errs {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			n++
+		}
+	}
+	return fmt.Sprintf("stomp: %d of %d messages failed: %v", n, len(e.Errs), first)
+}
+
+// Unwrap returns the errors of the failed messages.
+func (e *BatchError) Unwrap() []error {
+	var errs []error
+	for _, err := range e.Errs {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// SendBatch sends messages like Send, but encodes all of them into a single
+// write to the network connection. It is equivalent to sending the
+// messages with the zero Batch.
+func (c *Conn) SendBatch(msgs []Outgoing) error {
+	return Batch{}.Send(c, msgs)
+}
+
+// Send sends messages over c with a single write, see SendBatch. If the
+// write fails, the error applies to all messages. If receipts are
+// requested, messages that are not confirmed are reported by a *BatchError.
+//
+// Messages sent with a batch are not buffered while reconnecting and are
+// not sent through the outbox of the connection.
+func (b Batch) Send(c *Conn, msgs []Outgoing) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	var tx string
+	frames := make([]*Frame, 0, len(msgs)+2)
+	if b.Transaction {
+		tx = randID()
+		frames = append(frames, &Frame{
+			Command: "BEGIN",
+			Header:  Header{"transaction": tx},
+		})
+	}
+
+	for _, msg := range msgs {
+		f := &Frame{
+			Command: "SEND",
+			Header: Header{
+				"destination":  msg.Destination,
+				"content-type": msg.ContentType,
+			},
+			Body: msg.Body,
+		}
+		if len(msg.Body) > 0 {
+			f.Header["content-length"] = strconv.Itoa(len(msg.Body))
+		}
+		for _, fn := range msg.Options {
+			fn(f)
+		}
+
+		c.stampMessageID(f)
+		if tx != "" {
+			f.Header["transaction"] = tx
+		}
+		frames = append(frames, f)
+	}
+
+	if tx != "" {
+		frames = append(frames, &Frame{
+			Command: "COMMIT",
+			Header:  Header{"transaction": tx},
+		})
+	}
+
+	// the receipts must be awaited before the frames are written
+	var waits []<-chan error
+	var ids []string
+	if b.Receipts {
+		for _, f := range frames {
+			if f.Command == "SEND" || f.Command == "COMMIT" {
+				id := randID()
+				f.Header["receipt"] = id
+				ids = append(ids, id)
+				waits = append(waits, c.receipts.wait(id))
+			}
+		}
+	}
+
+	err := c.submit(frame{batch: frames, ch: make(chan error, 1)}, "SEND")
+	if err != nil || !b.Receipts {
+		for _, id := range ids {
+			c.receipts.cancel(id)
+		}
+		return err
+	}
+
+	timeout := b.ReceiptTimeout
+	if timeout <= 0 {
+		timeout = DefaultReceiptTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	errs := make([]error, len(waits))
+	expired := false
+	for i, wait := range waits {
+		if !expired {
+			select {
+			case errs[i] = <-wait:
+				continue
+			case <-timer.C:
+				expired = true
+			}
+		}
+
+		select {
+		case errs[i] = <-wait:
+		default:
+			c.receipts.cancel(ids[i])
+			errs[i] = errors.New("stomp: timeout waiting for receipt")
+		}
+	}
+
+	if tx != "" {
+		// nothing is delivered if the transaction was not committed
+		commit := errs[len(errs)-1]
+		errs = errs[:len(errs)-1]
+		if commit != nil {
+			for i := range errs {
+				errs[i] = commit
+			}
+		}
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return &BatchError{Errs: errs}
+		}
+	}
+	return nil
+}
+
+// writeBatch writes the frames of a batch with a single write and reports
+// the result to the caller. It returns an error if the connection failed.
+func (c *Conn) writeBatch(frame frame) error {
+	var data []byte
+	var sizes []int
+	var written []*Frame
+	for _, f := range frame.batch {
+		ok, err := c.outbound(f, frame.options)
+		if err != nil {
+			// nothing was written yet
+			frame.ch <- err
+			return nil
+		}
+		if !ok {
+			if id, ok := f.Header["receipt"]; ok {
+				c.receipts.received(id)
+			}
+			continue
+		}
+
+		encoded := encodeFrame(f)
+		data = append(data, encoded...)
+		sizes = append(sizes, len(encoded))
+		written = append(written, f)
+	}
+
+	c.setWriteDeadline()
+
+	for _, f := range written {
+		if id, ok := f.Header["receipt"]; ok {
+			c.receipts.sent(id)
+		}
+	}
+
+	_, err := c.conn.Write(data)
+	if err == nil {
+		for i, f := range written {
+			c.metrics.FrameSent(f.Command, sizes[i])
+		}
+	}
+	frame.ch <- err
+	return err
+}
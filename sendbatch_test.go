@@ -0,0 +1,98 @@
+package stomp_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"github.com/cumulodev/stomp/stomptest"
+)
+
+func TestSendBatch(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.Subscribe("/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := []stomp.Outgoing{
+		{Destination: "/queue/test", ContentType: "text/plain", Body: []byte("one")},
+		{Destination: "/queue/test", ContentType: "text/plain", Body: []byte("two"), Options: []stomp.Option{stomp.Persist()}},
+	}
+	if err := conn.SendBatch(msgs); err != nil {
+		t.Fatal(err)
+	}
+
+	b := stomp.Batch{Transaction: true, Receipts: true}
+	if err := b.Send(conn, msgs); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"one", "two", "one", "two"} {
+		if msg := receive(t, sub); string(msg.Body) != want {
+			t.Errorf("got message %q, want %q", msg.Body, want)
+		}
+	}
+
+	frames := s.Frames()
+	var commands []string
+	for _, f := range frames[2:] {
+		commands = append(commands, f.Command)
+	}
+	want := []string{"SEND", "SEND", "BEGIN", "SEND", "SEND", "COMMIT"}
+	if len(commands) < len(want) || !slices.Equal(commands[:len(want)], want) {
+		t.Errorf("got frames %v, want %v", commands, want)
+	}
+	if f := frames[3]; f.Header["persistent"] != "true" {
+		t.Errorf("got SEND frame %v, want options applied", f.Header)
+	}
+	for _, f := range frames[5:7] {
+		if f.Header["transaction"] == "" || f.Header["receipt"] == "" {
+			t.Errorf("got SEND frame %v, want transaction and receipt", f.Header)
+		}
+	}
+}
+
+func TestSendBatchReceiptTimeout(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	d := &stomp.Dialer{
+		Outbound: []stomp.Interceptor{func(f *stomp.Frame) error {
+			// lose the receipt of the second message
+			if string(f.Body) == "two" {
+				delete(f.Header, "receipt")
+			}
+			return nil
+		}},
+	}
+	conn, err := d.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msgs := []stomp.Outgoing{
+		{Destination: "/queue/test", Body: []byte("one")},
+		{Destination: "/queue/test", Body: []byte("two")},
+	}
+	b := stomp.Batch{Receipts: true, ReceiptTimeout: 100 * time.Millisecond}
+
+	var berr *stomp.BatchError
+	if err := b.Send(conn, msgs); !errors.As(err, &berr) {
+		t.Fatalf("got error %v, want BatchError", err)
+	}
+	if berr.Errs[0] != nil || berr.Errs[1] == nil {
+		t.Errorf("got errors %v, want only the second message failed", berr.Errs)
+	}
+}
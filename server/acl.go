@@ -0,0 +1,52 @@
+package server
+
+import "path"
+
+// Permission describes an operation on a destination.
+type Permission int
+
+const (
+	// Read permits subscribing to a destination.
+	Read Permission = 1 << iota
+
+	// Write permits sending messages to a destination.
+	Write
+)
+
+// A Rule grants permissions on destinations to a user.
+type Rule struct {
+	// Login is the login of the user the rule applies to. The rule applies
+	// to all users, including anonymous ones, if it is "*".
+	Login string
+
+	// Destination is a pattern matched against the destination name using
+	// the syntax of path.Match, for example "/queue/orders.*".
+	Destination string
+
+	// Allow is the set of permissions granted by the rule.
+	Allow Permission
+}
+
+// ACL is an access control list. Its Authorize method can be used as the
+// Authorize function of a Server.
+type ACL []Rule
+
+// Authorize reports whether the user with the given login has the permission
+// perm on destination. Rules are checked in order and the first rule matching
+// both the login and destination decides. Access is denied if no rule
+// matches.
+func (acl ACL) Authorize(login string, perm Permission, destination string) bool {
+	for _, rule := range acl {
+		if rule.Login != "*" && rule.Login != login {
+			continue
+		}
+
+		if ok, _ := path.Match(rule.Destination, destination); !ok {
+			continue
+		}
+
+		return rule.Allow&perm == perm
+	}
+
+	return false
+}
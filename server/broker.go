@@ -0,0 +1,201 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/cumulodev/stomp"
+)
+
+// A destination is either a queue or a topic together with its current
+// subscriptions. All fields are guarded by Server.mu.
+type destination struct {
+	name  string
+	topic bool
+	subs  []*subscription
+
+	// pending holds queue messages that are not yet delivered.
+	pending  []*stomp.Frame
+	position int
+}
+
+type subscription struct {
+	conn     *conn
+	id       string
+	dest     *destination
+	ack      stomp.AckMode
+	prefetch int
+	unacked  []*stomp.Frame
+}
+
+// destination returns the destination with the given name, creating it if
+// necessary. The caller must hold s.mu.
+func (s *Server) destination(name string) *destination {
+	d, ok := s.destinations[name]
+	if !ok {
+		d = &destination{
+			name:  name,
+			topic: strings.HasPrefix(name, s.topicPrefix()),
+		}
+		s.destinations[name] = d
+	}
+	return d
+}
+
+// publish routes the SEND frame f to its destination. The caller must hold
+// s.mu.
+func (s *Server) publish(f *stomp.Frame) error {
+	msg := &stomp.Frame{
+		Command: "MESSAGE",
+		Header:  make(stomp.Header, len(f.Header)+1),
+		Body:    f.Body,
+	}
+	for key, value := range f.Header {
+		if key != "receipt" && key != "transaction" {
+			msg.Header[key] = value
+		}
+	}
+	msg.Header["message-id"] = s.nextID()
+
+	d := s.destination(f.Header["destination"])
+	if d.topic {
+		for _, sub := range d.subs {
+			sub.deliver(msg)
+		}
+		return nil
+	}
+
+	if s.Store != nil {
+		if err := s.Store.Put(d.name, msg); err != nil {
+			return err
+		}
+	}
+
+	d.pending = append(d.pending, msg)
+	s.dispatch(d)
+	return nil
+}
+
+// dispatch delivers pending queue messages round-robin to the subscribers of
+// d that have not reached their prefetch limit. The caller must hold s.mu.
+func (s *Server) dispatch(d *destination) {
+	for len(d.pending) > 0 {
+		sub := d.next()
+		if sub == nil {
+			return
+		}
+
+		msg := d.pending[0]
+		d.pending = d.pending[1:]
+		sub.deliver(msg)
+	}
+}
+
+// requeue puts unacknowledged messages back in front of their queue to get
+// them redelivered. The caller must hold s.mu.
+func (s *Server) requeue(d *destination, msgs []*stomp.Frame) {
+	if len(msgs) == 0 || d.topic {
+		return
+	}
+
+	pending := make([]*stomp.Frame, 0, len(msgs)+len(d.pending))
+	for _, msg := range msgs {
+		redelivered := copyFrame(msg)
+		delete(redelivered.Header, "ack")
+		delete(redelivered.Header, "subscription")
+		redelivered.Header["redelivered"] = "true"
+		pending = append(pending, redelivered)
+	}
+	d.pending = append(pending, d.pending...)
+	s.dispatch(d)
+}
+
+// remove deletes acknowledged queue messages from the store. The caller must
+// hold s.mu.
+func (s *Server) remove(d *destination, msgs []*stomp.Frame) {
+	if s.Store == nil || d.topic {
+		return
+	}
+
+	for _, msg := range msgs {
+		if err := s.Store.Delete(d.name, msg.Header["message-id"]); err != nil {
+			s.logf("stomp: removing message %s from %s: %v", msg.Header["message-id"], d.name, err)
+		}
+	}
+}
+
+// next returns the next subscription in round-robin order that is able to
+// receive a message, or nil if there is none.
+func (d *destination) next() *subscription {
+	for i := 0; i < len(d.subs); i++ {
+		d.position = (d.position + 1) % len(d.subs)
+		if sub := d.subs[d.position]; sub.ready() {
+			return sub
+		}
+	}
+	return nil
+}
+
+func (d *destination) unsubscribe(sub *subscription) {
+	for i, s := range d.subs {
+		if s == sub {
+			d.subs = append(d.subs[:i:i], d.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// ready reports whether the subscription can receive another message without
+// exceeding its prefetch limit.
+func (sub *subscription) ready() bool {
+	return sub.prefetch <= 0 || len(sub.unacked) < sub.prefetch
+}
+
+// deliver sends a copy of msg to the subscriber. The caller must hold s.mu.
+func (sub *subscription) deliver(msg *stomp.Frame) {
+	s := sub.conn.srv
+	f := copyFrame(msg)
+	f.Header["subscription"] = sub.id
+
+	if sub.ack == stomp.AckAuto {
+		s.remove(sub.dest, []*stomp.Frame{f})
+	} else {
+		f.Header["ack"] = s.nextID()
+		sub.unacked = append(sub.unacked, f)
+	}
+
+	sub.conn.send(f)
+}
+
+// acknowledge removes the messages covered by the given ack identifier from
+// the unacknowledged messages and returns them. The caller must hold s.mu.
+func (sub *subscription) acknowledge(id string) []*stomp.Frame {
+	for i, f := range sub.unacked {
+		if f.Header["ack"] != id {
+			continue
+		}
+
+		var acked []*stomp.Frame
+		if sub.ack == stomp.AckClient {
+			acked = append(acked, sub.unacked[:i+1]...)
+			sub.unacked = append(sub.unacked[:0:0], sub.unacked[i+1:]...)
+		} else {
+			acked = append(acked, f)
+			sub.unacked = append(sub.unacked[:i:i], sub.unacked[i+1:]...)
+		}
+		return acked
+	}
+
+	return nil
+}
+
+func copyFrame(f *stomp.Frame) *stomp.Frame {
+	c := &stomp.Frame{
+		Command: f.Command,
+		Header:  make(stomp.Header, len(f.Header)),
+		Body:    f.Body,
+	}
+	for key, value := range f.Header {
+		c.Header[key] = value
+	}
+	return c
+}
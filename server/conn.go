@@ -0,0 +1,468 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cumulodev/stomp"
+)
+
+// A conn is the server side of a client connection.
+type conn struct {
+	srv     *Server
+	nc      net.Conn
+	decoder *stomp.Decoder
+	encoder *stomp.Encoder
+
+	// the following fields are guarded by srv.mu
+	connected    bool
+	login        string
+	subs         map[string]*subscription
+	transactions map[string][]*stomp.Frame
+
+	// heart-beat intervals negotiated on CONNECT, guarded by srv.mu
+	whb time.Duration
+	rhb time.Duration
+
+	outMu sync.Mutex
+	out   []*stomp.Frame
+	last  bool
+	wakeC chan struct{}
+
+	closeOnce sync.Once
+	closeC    chan struct{}
+}
+
+func newConn(srv *Server, nc net.Conn) *conn {
+	return &conn{
+		srv:     srv,
+		nc:      nc,
+		decoder: stomp.NewDecoder(nc),
+		encoder: stomp.NewEncoder(nc),
+
+		subs:         make(map[string]*subscription),
+		transactions: make(map[string][]*stomp.Frame),
+
+		wakeC:  make(chan struct{}, 1),
+		closeC: make(chan struct{}),
+	}
+}
+
+// send queues f for writing to the client without blocking.
+func (c *conn) send(f *stomp.Frame) {
+	c.outMu.Lock()
+	if !c.last {
+		c.out = append(c.out, f)
+	}
+	c.outMu.Unlock()
+	c.wake()
+}
+
+// sendLast queues f as the last frame for the client. The connection is
+// closed once it is written.
+func (c *conn) sendLast(f *stomp.Frame) {
+	c.outMu.Lock()
+	if !c.last {
+		c.out = append(c.out, f)
+		c.last = true
+	}
+	c.outMu.Unlock()
+	c.wake()
+}
+
+// fail sends an ERROR frame to the client and closes the connection
+// afterwards.
+func (c *conn) fail(message string, cause *stomp.Frame) {
+	f := &stomp.Frame{
+		Command: "ERROR",
+		Header:  stomp.Header{"message": message},
+	}
+	if cause != nil {
+		if receipt, ok := cause.Header["receipt"]; ok {
+			f.Header["receipt-id"] = receipt
+		}
+	}
+
+	c.sendLast(f)
+}
+
+func (c *conn) wake() {
+	select {
+	case c.wakeC <- struct{}{}:
+	default:
+	}
+}
+
+func (c *conn) close() {
+	c.closeOnce.Do(func() {
+		close(c.closeC)
+		c.nc.Close()
+	})
+}
+
+func (c *conn) writeLoop() {
+	defer c.close()
+
+	var beat <-chan time.Time
+	for {
+		select {
+		case <-c.closeC:
+			return
+
+		case <-beat:
+			c.send(&stomp.Frame{})
+
+		case <-c.wakeC:
+		}
+
+		c.outMu.Lock()
+		out, last := c.out, c.last
+		c.out = nil
+		c.outMu.Unlock()
+
+		for _, f := range out {
+			if err := c.encoder.Encode(f); err != nil {
+				return
+			}
+		}
+
+		if last {
+			return
+		}
+
+		c.srv.mu.Lock()
+		whb := c.whb
+		c.srv.mu.Unlock()
+		if whb > 0 {
+			beat = time.After(whb)
+		}
+	}
+}
+
+func (c *conn) readLoop() {
+	defer c.disconnect()
+
+	for {
+		c.srv.mu.Lock()
+		rhb := c.rhb
+		c.srv.mu.Unlock()
+
+		if rhb > 0 {
+			// allow the heart-beat to arrive late, e.g. due to network
+			// latency
+			c.nc.SetReadDeadline(time.Now().Add(2 * rhb))
+		} else {
+			c.nc.SetReadDeadline(time.Time{})
+		}
+
+		f, err := c.decoder.Decode()
+		if err != nil {
+			return
+		}
+
+		if f.Command == "" {
+			// heart-beat
+			continue
+		}
+
+		c.srv.mu.Lock()
+		ok := c.handle(f)
+		c.srv.mu.Unlock()
+
+		if !ok {
+			return
+		}
+	}
+}
+
+// disconnect removes the connection from the server and redelivers all
+// messages it did not acknowledge.
+func (c *conn) disconnect() {
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+
+	for id := range c.subs {
+		c.unsubscribe(id)
+	}
+
+	c.connected = false
+	delete(c.srv.conns, c)
+
+	// let the write loop flush a pending ERROR frame before closing
+	c.outMu.Lock()
+	last := c.last
+	c.outMu.Unlock()
+	if !last {
+		c.close()
+	}
+}
+
+// handle processes a frame received from the client. It returns false if the
+// connection must be closed. The caller must hold srv.mu.
+func (c *conn) handle(f *stomp.Frame) bool {
+	if !c.connected && f.Command != "CONNECT" && f.Command != "STOMP" {
+		c.fail("not connected", f)
+		return false
+	}
+
+	var err error
+	switch f.Command {
+	case "CONNECT", "STOMP":
+		// CONNECT frames are never answered with a receipt
+		return c.connect(f)
+
+	case "DISCONNECT":
+		if receipt, ok := f.Header["receipt"]; ok {
+			c.sendLast(&stomp.Frame{
+				Command: "RECEIPT",
+				Header:  stomp.Header{"receipt-id": receipt},
+			})
+		}
+		return false
+
+	case "SEND":
+		destination := f.Header["destination"]
+		if destination == "" {
+			err = fmt.Errorf("SEND requires a destination header")
+			break
+		}
+
+		if !c.authorize(Write, destination) {
+			err = fmt.Errorf("access to %s denied", destination)
+			break
+		}
+		err = c.transact(f)
+
+	case "ACK", "NACK":
+		err = c.transact(f)
+
+	case "SUBSCRIBE":
+		err = c.subscribe(f)
+
+	case "UNSUBSCRIBE":
+		if _, ok := c.subs[f.Header["id"]]; !ok {
+			err = fmt.Errorf("unknown subscription %q", f.Header["id"])
+			break
+		}
+		c.unsubscribe(f.Header["id"])
+
+	case "BEGIN":
+		tx := f.Header["transaction"]
+		if _, ok := c.transactions[tx]; ok || tx == "" {
+			err = fmt.Errorf("invalid transaction %q", tx)
+			break
+		}
+		c.transactions[tx] = []*stomp.Frame{}
+
+	case "COMMIT", "ABORT":
+		tx := f.Header["transaction"]
+		frames, ok := c.transactions[tx]
+		if !ok {
+			err = fmt.Errorf("unknown transaction %q", tx)
+			break
+		}
+
+		delete(c.transactions, tx)
+		if f.Command == "COMMIT" {
+			for _, f := range frames {
+				if err = c.apply(f); err != nil {
+					break
+				}
+			}
+		}
+
+	default:
+		err = fmt.Errorf("unknown command %q", f.Command)
+	}
+
+	if err != nil {
+		c.fail(err.Error(), f)
+		return false
+	}
+
+	if receipt, ok := f.Header["receipt"]; ok {
+		c.send(&stomp.Frame{
+			Command: "RECEIPT",
+			Header:  stomp.Header{"receipt-id": receipt},
+		})
+	}
+
+	return true
+}
+
+func (c *conn) connect(f *stomp.Frame) bool {
+	if c.connected {
+		c.fail("already connected", f)
+		return false
+	}
+
+	if versions, ok := f.Header["accept-version"]; ok && !contains(strings.Split(versions, ","), "1.2") {
+		c.fail(fmt.Sprintf("unsupported protocol versions %q", versions), f)
+		return false
+	}
+
+	login := f.Header["login"]
+	if c.srv.Authenticate != nil {
+		if err := c.srv.Authenticate(login, f.Header["passcode"]); err != nil {
+			c.fail(fmt.Sprintf("authentication failed: %v", err), f)
+			return false
+		}
+	}
+
+	// negotiate heart-beating, see the "Heart-beating" section of the
+	// STOMP specification
+	cx, cy := parseHeartBeat(f.Header["heart-beat"])
+	sx, sy := c.srv.SendHeartBeat, c.srv.RecvHeartBeat
+	if sx > 0 && cy > 0 {
+		c.whb = max(sx, cy)
+	}
+	if cx > 0 && sy > 0 {
+		c.rhb = max(cx, sy)
+	}
+
+	c.connected = true
+	c.login = login
+	c.send(&stomp.Frame{
+		Command: "CONNECTED",
+		Header: stomp.Header{
+			"version":    "1.2",
+			"server":     "cumulodev-stomp",
+			"session":    c.srv.nextID(),
+			"heart-beat": fmt.Sprintf("%d,%d", sx/time.Millisecond, sy/time.Millisecond),
+		},
+	})
+
+	return true
+}
+
+func (c *conn) authorize(perm Permission, destination string) bool {
+	return c.srv.Authorize == nil || c.srv.Authorize(c.login, perm, destination)
+}
+
+// transact applies f or buffers it if it is part of a transaction.
+func (c *conn) transact(f *stomp.Frame) error {
+	tx, ok := f.Header["transaction"]
+	if !ok {
+		return c.apply(f)
+	}
+
+	frames, ok := c.transactions[tx]
+	if !ok {
+		return fmt.Errorf("unknown transaction %q", tx)
+	}
+
+	c.transactions[tx] = append(frames, f)
+	return nil
+}
+
+// apply executes the transactional frame f.
+func (c *conn) apply(f *stomp.Frame) error {
+	switch f.Command {
+	case "SEND":
+		if err := c.srv.publish(f); err != nil {
+			c.srv.logf("stomp: storing message for %s: %v", f.Header["destination"], err)
+			return fmt.Errorf("message not accepted")
+		}
+
+	case "ACK", "NACK":
+		id := f.Header["id"]
+		for _, sub := range c.subs {
+			acked := sub.acknowledge(id)
+			if acked == nil {
+				continue
+			}
+
+			if f.Command == "NACK" {
+				c.srv.requeue(sub.dest, acked)
+			} else {
+				c.srv.remove(sub.dest, acked)
+				c.srv.dispatch(sub.dest)
+			}
+			return nil
+		}
+
+		return fmt.Errorf("unknown ack id %q", id)
+	}
+
+	return nil
+}
+
+func (c *conn) subscribe(f *stomp.Frame) error {
+	id, destination := f.Header["id"], f.Header["destination"]
+	if id == "" || destination == "" {
+		return fmt.Errorf("SUBSCRIBE requires id and destination headers")
+	}
+
+	if _, ok := c.subs[id]; ok {
+		return fmt.Errorf("duplicate subscription %q", id)
+	}
+
+	if !c.authorize(Read, destination) {
+		return fmt.Errorf("access to %s denied", destination)
+	}
+
+	ack := stomp.AckMode(f.Header["ack"])
+	switch ack {
+	case "":
+		ack = stomp.AckAuto
+	case stomp.AckAuto, stomp.AckClient, stomp.AckIndividual:
+	default:
+		return fmt.Errorf("invalid ack mode %q", ack)
+	}
+
+	prefetch := 0
+	if value, ok := f.Header["prefetch-count"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid prefetch-count %q", value)
+		}
+		prefetch = n
+	}
+
+	d := c.srv.destination(destination)
+	sub := &subscription{
+		conn:     c,
+		id:       id,
+		dest:     d,
+		ack:      ack,
+		prefetch: prefetch,
+	}
+	c.subs[id] = sub
+	d.subs = append(d.subs, sub)
+	c.srv.dispatch(d)
+	return nil
+}
+
+func (c *conn) unsubscribe(id string) {
+	sub := c.subs[id]
+	delete(c.subs, id)
+
+	sub.dest.unsubscribe(sub)
+	unacked := sub.unacked
+	sub.unacked = nil
+	c.srv.requeue(sub.dest, unacked)
+}
+
+func parseHeartBeat(header string) (time.Duration, time.Duration) {
+	beats := strings.Split(header, ",")
+	if len(beats) != 2 {
+		return 0, 0
+	}
+
+	x, _ := strconv.Atoi(strings.TrimSpace(beats[0]))
+	y, _ := strconv.Atoi(strings.TrimSpace(beats[1]))
+	return time.Duration(x) * time.Millisecond, time.Duration(y) * time.Millisecond
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if strings.TrimSpace(v) == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,284 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cumulodev/stomp"
+)
+
+// compactThreshold is the minimum number of deleted records in a log file
+// before it is compacted.
+const compactThreshold = 1024
+
+// FileStore is a Store persisting messages in a directory. Each destination
+// is stored in an append-only log file containing the STOMP encoding of its
+// messages and deletion records. Log files are compacted when they consist
+// mostly of deleted messages.
+type FileStore struct {
+	// Sync, if true, commits every write to stable storage before
+	// returning. This guarantees that no acknowledged SEND frame is lost
+	// on power failure, at the expense of throughput.
+	Sync bool
+
+	dir  string
+	mu   sync.Mutex
+	logs map[string]*fileLog
+}
+
+type fileLog struct {
+	file    *os.File
+	encoder *stomp.Encoder
+	live    int
+	dead    int
+}
+
+// OpenFileStore returns a FileStore keeping its log files in dir. The
+// directory is created if it does not exist.
+func OpenFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{
+		dir:  dir,
+		logs: make(map[string]*fileLog),
+	}, nil
+}
+
+// Put implements the Store interface.
+func (s *FileStore) Put(destination string, msg *stomp.Frame) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, err := s.open(destination)
+	if err != nil {
+		return err
+	}
+
+	record := copyFrame(msg)
+	record.Header["content-length"] = fmt.Sprintf("%d", len(record.Body))
+	if err := s.write(l, record); err != nil {
+		return err
+	}
+
+	l.live++
+	return nil
+}
+
+// Delete implements the Store interface.
+func (s *FileStore) Delete(destination, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, err := s.open(destination)
+	if err != nil {
+		return err
+	}
+
+	record := &stomp.Frame{
+		Command: "DELETE",
+		Header:  stomp.Header{"message-id": id},
+	}
+	if err := s.write(l, record); err != nil {
+		return err
+	}
+
+	l.live--
+	l.dead++
+	if l.dead >= compactThreshold && l.dead > l.live {
+		return s.compact(destination)
+	}
+	return nil
+}
+
+// Load implements the Store interface. Incomplete records at the end of a
+// log file, as left behind by a crash, are discarded. A log file that is
+// corrupt elsewhere is left untouched and reported as an error.
+func (s *FileStore) Load() (map[string][]*stomp.Frame, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := filepath.Glob(filepath.Join(s.dir, "*.log"))
+	if err != nil {
+		return nil, err
+	}
+
+	queues := make(map[string][]*stomp.Frame)
+	for _, name := range names {
+		destination, err := url.PathUnescape(strings.TrimSuffix(filepath.Base(name), ".log"))
+		if err != nil {
+			continue
+		}
+
+		// compaction rewrites the log without deleted and incomplete
+		// records
+		if err := s.compact(destination); err != nil {
+			return nil, err
+		}
+
+		msgs, err := s.read(destination)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(msgs) > 0 {
+			queues[destination] = msgs
+		}
+	}
+
+	return queues, nil
+}
+
+// Close implements the Store interface.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for destination, l := range s.logs {
+		if cerr := l.file.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(s.logs, destination)
+	}
+	return err
+}
+
+func (s *FileStore) path(destination string) string {
+	return filepath.Join(s.dir, url.PathEscape(destination)+".log")
+}
+
+// open returns the log of destination, opening the file if necessary. The
+// caller must hold s.mu.
+func (s *FileStore) open(destination string) (*fileLog, error) {
+	if l, ok := s.logs[destination]; ok {
+		return l, nil
+	}
+
+	file, err := os.OpenFile(s.path(destination), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &fileLog{file: file, encoder: stomp.NewEncoder(file)}
+	s.logs[destination] = l
+	return l, nil
+}
+
+func (s *FileStore) write(l *fileLog, record *stomp.Frame) error {
+	if err := l.encoder.Encode(record); err != nil {
+		return err
+	}
+
+	if s.Sync {
+		return l.file.Sync()
+	}
+	return nil
+}
+
+// read returns the messages in the log of destination that have not been
+// deleted. The caller must hold s.mu.
+func (s *FileStore) read(destination string) ([]*stomp.Frame, error) {
+	file, err := os.Open(s.path(destination))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var msgs []*stomp.Frame
+	index := make(map[string]int)
+	decoder := stomp.NewDecoder(file)
+	for {
+		record, err := decoder.Decode()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// end of the log or a torn write at its end
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("corrupt log %s: %w", file.Name(), err)
+		}
+
+		id := record.Header["message-id"]
+		switch record.Command {
+		case "MESSAGE":
+			index[id] = len(msgs)
+			msgs = append(msgs, record)
+
+		case "DELETE":
+			if i, ok := index[id]; ok {
+				msgs[i] = nil
+				delete(index, id)
+			}
+		}
+	}
+
+	live := msgs[:0]
+	for _, msg := range msgs {
+		if msg != nil {
+			live = append(live, msg)
+		}
+	}
+	return live, nil
+}
+
+// compact rewrites the log of destination so that it only contains the
+// messages that have not been deleted. The caller must hold s.mu.
+func (s *FileStore) compact(destination string) error {
+	msgs, err := s.read(destination)
+	if err != nil {
+		return err
+	}
+
+	if l, ok := s.logs[destination]; ok {
+		l.file.Close()
+		delete(s.logs, destination)
+	}
+
+	name := s.path(destination)
+	if len(msgs) == 0 {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(s.dir, "compact-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	encoder := stomp.NewEncoder(tmp)
+	for _, msg := range msgs {
+		if err := encoder.Encode(msg); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+
+	l, err := s.open(destination)
+	if err != nil {
+		return err
+	}
+	l.live = len(msgs)
+	return nil
+}
@@ -0,0 +1,272 @@
+// Package server implements a STOMP 1.2 message broker.
+//
+// A Server accepts client connections on any number of TCP, TLS or WebSocket
+// listeners and routes messages between them. Destinations starting with the
+// topic prefix ("/topic/" by default) have publish-subscribe semantics: every
+// subscriber receives a copy of each message and messages sent while there
+// is no subscriber are discarded. All other destinations are queues with
+// point-to-point semantics: each message is delivered to exactly one
+// subscriber and kept until it is acknowledged. Queued messages are persisted
+// in the configured Store and survive a restart of the server.
+//
+// Subscriptions using the client or client-individual acknowledgment modes
+// may limit the number of unacknowledged messages in flight with the
+// "prefetch-count" header of the SUBSCRIBE frame.
+//
+// Frames are encoded and decoded with the codec of the stomp package, so the
+// server and the client library always agree on the wire format.
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed is returned by the Serve and ListenAndServe methods after
+// a call to Close.
+var ErrServerClosed = errors.New("stomp: server closed")
+
+// A Server defines parameters for running a STOMP broker. The zero value for
+// Server is a valid configuration without authentication, authorization or
+// persistence.
+type Server struct {
+	// TLSConfig optionally provides a TLS configuration for use by
+	// ListenAndServeTLS.
+	TLSConfig *tls.Config
+
+	// Store persists the messages of queue destinations. If nil, messages
+	// are only kept in memory and lost when the server stops.
+	Store Store
+
+	// Authenticate, if non-nil, is called with the login and passcode
+	// headers of each CONNECT frame. The connection is refused if it
+	// returns an error.
+	Authenticate func(login, passcode string) error
+
+	// Authorize, if non-nil, is called before a client sends to or
+	// subscribes to a destination. The frame is rejected with an ERROR
+	// frame if it returns false. See ACL for a rule based implementation.
+	Authorize func(login string, perm Permission, destination string) bool
+
+	// TopicPrefix is the prefix of destinations with publish-subscribe
+	// semantics. If empty, "/topic/" is used.
+	TopicPrefix string
+
+	// SendHeartBeat is the smallest interval the server can guarantee
+	// between outgoing heart-beats. Zero means the server cannot send
+	// heart-beats.
+	SendHeartBeat time.Duration
+
+	// RecvHeartBeat is the desired interval between heart-beats received
+	// from clients. Zero means the server does not want heart-beats.
+	RecvHeartBeat time.Duration
+
+	// ErrorLog specifies an optional logger for errors accepting
+	// connections and unexpected behavior from the store. If nil, logging
+	// is done via the log package's standard logger.
+	ErrorLog *log.Logger
+
+	initOnce sync.Once
+	initErr  error
+	id       string
+
+	mu           sync.Mutex
+	listeners    map[net.Listener]struct{}
+	conns        map[*conn]struct{}
+	destinations map[string]*destination
+	sequence     int
+	closed       bool
+	wg           sync.WaitGroup
+}
+
+// ListenAndServe listens on the TCP network address addr and then calls Serve
+// to handle incoming connections.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// ListenAndServeTLS acts identically to ListenAndServe, except that it
+// expects TLS connections. Certificate and private key files must be
+// provided unless the TLSConfig of the server already contains
+// certificates.
+func (s *Server) ListenAndServeTLS(addr, certFile, keyFile string) error {
+	config := &tls.Config{}
+	if s.TLSConfig != nil {
+		config = s.TLSConfig.Clone()
+	}
+
+	if len(config.Certificates) == 0 || certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+
+	l, err := tls.Listen("tcp", addr, config)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve accepts incoming connections on the listener l, creating a new
+// service goroutine for each. Serve always returns a non-nil error and closes
+// l. After Close, the returned error is ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	defer l.Close()
+
+	if err := s.init(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	var delay time.Duration
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				// back off on temporary errors, e.g. too many open files
+				delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+				s.logf("accept error: %v; retrying in %v", err, delay)
+				time.Sleep(delay)
+				continue
+			}
+
+			return err
+		}
+
+		delay = 0
+		go s.ServeConn(nc)
+	}
+}
+
+// ServeConn serves STOMP on a single connection and blocks until the
+// connection is closed. It is used to serve transports that are not
+// accepted from a net.Listener, such as WebSocket connections.
+func (s *Server) ServeConn(nc net.Conn) {
+	if err := s.init(); err != nil {
+		nc.Close()
+		return
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		nc.Close()
+		return
+	}
+
+	c := newConn(s, nc)
+	s.conns[c] = struct{}{}
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	defer s.wg.Done()
+	go c.writeLoop()
+	c.readLoop()
+}
+
+// Close immediately closes all listeners and connections and then closes the
+// store. Messages that are not yet acknowledged remain in the store.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	if s.Store != nil {
+		return s.Store.Close()
+	}
+	return nil
+}
+
+// init restores the persisted queues from the store on first use.
+func (s *Server) init() error {
+	s.initOnce.Do(func() {
+		s.id = strconv.FormatInt(time.Now().UnixNano(), 36)
+		s.listeners = make(map[net.Listener]struct{})
+		s.conns = make(map[*conn]struct{})
+		s.destinations = make(map[string]*destination)
+
+		if s.Store == nil {
+			return
+		}
+
+		queues, err := s.Store.Load()
+		if err != nil {
+			s.initErr = fmt.Errorf("stomp: loading store: %v", err)
+			return
+		}
+
+		for name, msgs := range queues {
+			d := s.destination(name)
+			d.pending = append(d.pending, msgs...)
+		}
+	})
+
+	return s.initErr
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// nextID returns a message identifier unique across restarts of the server.
+// The caller must hold s.mu.
+func (s *Server) nextID() string {
+	s.sequence++
+	return fmt.Sprintf("%s-%d", s.id, s.sequence)
+}
+
+func (s *Server) topicPrefix() string {
+	if s.TopicPrefix == "" {
+		return "/topic/"
+	}
+	return s.TopicPrefix
+}
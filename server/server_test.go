@@ -0,0 +1,320 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"golang.org/x/net/websocket"
+)
+
+func TestQueue(t *testing.T) {
+	s, addr := serve(t, &Server{})
+
+	conn, err := stomp.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// messages are kept until a subscriber is available
+	if err := conn.Send("/queue/test", "text/plain", []byte("hello\x00world")); err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := conn.Subscribe("/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-sub.C:
+		if string(msg.Body) != "hello\x00world" {
+			t.Errorf("got body %q, want %q", msg.Body, "hello\x00world")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+
+	s.mu.Lock()
+	pending := len(s.destinations["/queue/test"].pending)
+	s.mu.Unlock()
+	if pending != 0 {
+		t.Errorf("got %d pending messages, want none", pending)
+	}
+}
+
+func TestTopic(t *testing.T) {
+	_, addr := serve(t, &Server{})
+
+	c1, c2 := dial(t, addr), dial(t, addr)
+	c1.write(t, "SUBSCRIBE", stomp.Header{"id": "0", "destination": "/topic/test", "receipt": "r"})
+	c2.write(t, "SUBSCRIBE", stomp.Header{"id": "0", "destination": "/topic/test", "receipt": "r"})
+	c1.read(t, "RECEIPT")
+	c2.read(t, "RECEIPT")
+
+	c1.write(t, "SEND", stomp.Header{"destination": "/topic/test"})
+	c1.read(t, "MESSAGE")
+	c2.read(t, "MESSAGE")
+}
+
+func TestPrefetch(t *testing.T) {
+	_, addr := serve(t, &Server{})
+
+	c := dial(t, addr)
+	c.write(t, "SUBSCRIBE", stomp.Header{"id": "0", "destination": "/queue/test", "ack": "client-individual", "prefetch-count": "1"})
+	c.write(t, "SEND", stomp.Header{"destination": "/queue/test"}, "a")
+	c.write(t, "SEND", stomp.Header{"destination": "/queue/test", "receipt": "r"}, "b")
+
+	msg := c.read(t, "MESSAGE")
+	c.read(t, "RECEIPT")
+
+	// the second message is held back until the first is acknowledged
+	c.write(t, "ACK", stomp.Header{"id": msg.Header["ack"]})
+	if msg := c.read(t, "MESSAGE"); string(msg.Body) != "b" {
+		t.Errorf("got message %q, want %q", msg.Body, "b")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	_, addr := serve(t, &Server{
+		Authenticate: func(login, passcode string) error {
+			if login != "admin" || passcode != "secret" {
+				return errors.New("invalid credentials")
+			}
+			return nil
+		},
+	})
+
+	if _, err := stomp.Dial("tcp", addr, stomp.Authenticate("admin", "wrong")); err == nil {
+		t.Error("connected with invalid credentials")
+	}
+
+	conn, err := stomp.Dial("tcp", addr, stomp.Authenticate("admin", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestAuthorize(t *testing.T) {
+	acl := ACL{
+		{Login: "admin", Destination: "/*/*", Allow: Read | Write},
+		{Login: "*", Destination: "/queue/public.*", Allow: Read},
+	}
+
+	tests := []struct {
+		login       string
+		perm        Permission
+		destination string
+		allowed     bool
+	}{
+		{"admin", Write, "/queue/orders", true},
+		{"guest", Read, "/queue/public.news", true},
+		{"guest", Write, "/queue/public.news", false},
+		{"guest", Read, "/queue/orders", false},
+	}
+
+	for _, test := range tests {
+		if allowed := acl.Authorize(test.login, test.perm, test.destination); allowed != test.allowed {
+			t.Errorf("Authorize(%q, %v, %q) = %v, want %v", test.login, test.perm, test.destination, allowed, test.allowed)
+		}
+	}
+
+	_, addr := serve(t, &Server{Authorize: acl.Authorize})
+	c := dial(t, addr)
+	c.write(t, "SEND", stomp.Header{"destination": "/queue/public.news"})
+	if f := c.read(t, "ERROR"); !strings.Contains(f.Header["message"], "denied") {
+		t.Errorf("got error %q, want access denied", f.Header["message"])
+	}
+}
+
+func TestRestart(t *testing.T) {
+	store, err := OpenFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, addr := serve(t, &Server{Store: store})
+	c := dial(t, addr)
+	c.write(t, "SEND", stomp.Header{"destination": "/queue/test"}, "acked")
+	c.write(t, "SEND", stomp.Header{"destination": "/queue/test"}, "unacked")
+	c.write(t, "SUBSCRIBE", stomp.Header{"id": "0", "destination": "/queue/test", "ack": "client-individual"})
+	msg := c.read(t, "MESSAGE")
+	c.write(t, "ACK", stomp.Header{"id": msg.Header["ack"], "receipt": "r"})
+	c.read(t, "MESSAGE")
+	c.read(t, "RECEIPT")
+	s.Close()
+
+	store, err = OpenFileStore(store.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, addr = serve(t, &Server{Store: store})
+	c = dial(t, addr)
+	c.write(t, "SUBSCRIBE", stomp.Header{"id": "0", "destination": "/queue/test"})
+	if msg := c.read(t, "MESSAGE"); string(msg.Body) != "unacked" {
+		t.Errorf("got message %q after restart, want %q", msg.Body, "unacked")
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	s := &Server{}
+	ts := httptest.NewServer(s.WebSocketHandler())
+	defer ts.Close()
+	defer s.Close()
+
+	config, err := websocket.NewConfig("ws"+strings.TrimPrefix(ts.URL, "http"), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Protocol = []string{"v12.stomp"}
+
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	c := &client{nc: ws, encoder: stomp.NewEncoder(ws), decoder: stomp.NewDecoder(ws)}
+	c.write(t, "CONNECT", stomp.Header{"accept-version": "1.2"})
+	c.read(t, "CONNECTED")
+	c.write(t, "SUBSCRIBE", stomp.Header{"id": "0", "destination": "/queue/test"})
+	c.write(t, "SEND", stomp.Header{"destination": "/queue/test"}, "hello")
+	if msg := c.read(t, "MESSAGE"); string(msg.Body) != "hello" {
+		t.Errorf("got message %q, want %q", msg.Body, "hello")
+	}
+}
+
+// serve starts s on a loopback port and returns its address.
+func serve(t *testing.T, s *Server) (*Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return s, l.Addr().String()
+}
+
+type client struct {
+	nc      net.Conn
+	encoder *stomp.Encoder
+	decoder *stomp.Decoder
+}
+
+// dial opens a raw client connection and sends a CONNECT frame.
+func dial(t *testing.T, addr string) *client {
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nc.Close() })
+
+	c := &client{
+		nc:      nc,
+		encoder: stomp.NewEncoder(nc),
+		decoder: stomp.NewDecoder(nc),
+	}
+
+	c.write(t, "CONNECT", stomp.Header{"accept-version": "1.2"})
+	c.read(t, "CONNECTED")
+	return c
+}
+
+func (c *client) write(t *testing.T, command string, header stomp.Header, body ...string) {
+	t.Helper()
+	f := &stomp.Frame{Command: command, Header: header}
+	if len(body) > 0 {
+		f.Body = []byte(body[0])
+	}
+
+	if err := c.encoder.Encode(f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// read returns the next frame, skipping heart-beats.
+func (c *client) read(t *testing.T, command string) *stomp.Frame {
+	t.Helper()
+	c.nc.SetReadDeadline(time.Now().Add(time.Second))
+
+	for {
+		f, err := c.decoder.Decode()
+		if err != nil {
+			t.Fatalf("waiting for %s frame: %v", command, err)
+		}
+
+		if f.Command == "" {
+			continue
+		}
+
+		if f.Command != command {
+			t.Fatalf("got %s frame (%v), want %s", f.Command, f.Header, command)
+		}
+		return f
+	}
+}
+
+func TestFileStoreCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, body := range []string{"one", "two", "three"} {
+		msg := &stomp.Frame{
+			Command: "MESSAGE",
+			Header:  stomp.Header{"message-id": strconv.Itoa(i), "destination": "/queue/test"},
+			Body:    []byte(body),
+		}
+		if err := store.Put("/queue/test", msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+
+	name := store.path("/queue/test")
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a torn write at the end of the log is discarded
+	if err := os.WriteFile(name, data[:len(data)-3], 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, _ = OpenFileStore(dir)
+	queues, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(queues["/queue/test"]); n != 2 {
+		t.Errorf("got %d messages after torn write, want 2", n)
+	}
+	store.Close()
+
+	// a corrupt record in the middle fails without losing the messages
+	// after it
+	corrupt := bytes.Replace(data, []byte("one\x00"), []byte("oneX"), 1)
+	if err := os.WriteFile(name, corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, _ = OpenFileStore(dir)
+	if _, err := store.Load(); err == nil {
+		t.Error("loaded corrupt log")
+	}
+	store.Close()
+	if got, err := os.ReadFile(name); err != nil || !bytes.Equal(got, corrupt) {
+		t.Errorf("corrupt log changed by Load: %v", err)
+	}
+}
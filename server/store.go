@@ -0,0 +1,87 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/cumulodev/stomp"
+)
+
+// A Store persists the messages of queue destinations. A message is put into
+// the store when it is sent to a queue and deleted once a consumer has
+// acknowledged it. Messages sent to topics are never stored.
+//
+// The methods of a Store are never called concurrently by the server.
+type Store interface {
+	// Put stores the MESSAGE frame msg for the given destination. The frame
+	// carries a unique message-id header.
+	Put(destination string, msg *stomp.Frame) error
+
+	// Delete removes the message with the given message-id from the
+	// destination.
+	Delete(destination, id string) error
+
+	// Load returns all stored messages by destination, each in the order
+	// they were put into the store. It is called once when the server
+	// starts.
+	Load() (map[string][]*stomp.Frame, error)
+
+	// Close releases any resources held by the store.
+	Close() error
+}
+
+// MemoryStore is a Store keeping messages in memory. Messages do not survive
+// the process, but are handed over to a new Server using the same store.
+type MemoryStore struct {
+	mu     sync.Mutex
+	queues map[string][]*stomp.Frame
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{queues: make(map[string][]*stomp.Frame)}
+}
+
+// Put implements the Store interface.
+func (s *MemoryStore) Put(destination string, msg *stomp.Frame) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queues[destination] = append(s.queues[destination], msg)
+	return nil
+}
+
+// Delete implements the Store interface.
+func (s *MemoryStore) Delete(destination, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msgs := s.queues[destination]
+	for i, msg := range msgs {
+		if msg.Header["message-id"] == id {
+			s.queues[destination] = append(msgs[:i:i], msgs[i+1:]...)
+			break
+		}
+	}
+
+	if len(s.queues[destination]) == 0 {
+		delete(s.queues, destination)
+	}
+	return nil
+}
+
+// Load implements the Store interface.
+func (s *MemoryStore) Load() (map[string][]*stomp.Frame, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queues := make(map[string][]*stomp.Frame, len(s.queues))
+	for destination, msgs := range s.queues {
+		queues[destination] = append([]*stomp.Frame(nil), msgs...)
+	}
+	return queues, nil
+}
+
+// Close implements the Store interface.
+func (s *MemoryStore) Close() error {
+	return nil
+}
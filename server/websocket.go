@@ -0,0 +1,37 @@
+package server
+
+import (
+	"net/http"
+
+	"golang.org/x/net/websocket"
+)
+
+// webSocketProtocols lists the WebSocket sub-protocols registered for STOMP
+// in order of preference.
+var webSocketProtocols = []string{"v12.stomp", "v11.stomp", "v10.stomp"}
+
+// WebSocketHandler returns an http.Handler serving STOMP over WebSocket as
+// used by browser clients like stomp.js. Each WebSocket message carries one
+// STOMP frame. The handler negotiates the "v12.stomp" sub-protocol if the
+// client offers it. Cross-origin requests are accepted; wrap the handler to
+// restrict them.
+func (s *Server) WebSocketHandler() http.Handler {
+	return websocket.Server{
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			for _, protocol := range webSocketProtocols {
+				for _, offered := range config.Protocol {
+					if offered == protocol {
+						config.Protocol = []string{protocol}
+						return nil
+					}
+				}
+			}
+
+			config.Protocol = nil
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			s.ServeConn(ws)
+		},
+	}
+}
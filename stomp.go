@@ -2,11 +2,13 @@ package stomp
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
-	"log"
-	"math/rand"
+	"log/slog"
+	"maps"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -49,24 +51,68 @@ type Conn struct {
 	Reconnect        func(n int, d time.Duration, err error) (bool, time.Duration)
 	ReconnectSuccess func(n int)
 
-	reader *bufio.Reader
+	decoder *Decoder
 
 	network string
 	addr    string
 	options []Option
+	dial    func(network, addr string) (net.Conn, error)
+	strict  bool
 	conn    net.Conn
 
 	subsMu sync.Mutex
 	subs   map[string]*Subscription
 
-	rhb time.Duration
-	whb time.Duration
+	heartbeat *heartbeatMonitor
+	logger    *slog.Logger
+	metrics   Metrics
+	receipts  receiptTracker
+
+	outboundInterceptors []Interceptor
+	inboundInterceptors  []Interceptor
+	onReject             func(f *Frame, err error)
+	disableDecompression bool
+	maxDecompressedSize  int
+	streamThreshold      int64
+
+	// streaming is set while the body of a streamed message is read.
+	streaming atomic.Bool
+
+	// messageIDHeader is stamped on SEND frames with the producer ID and
+	// a sequence number.
+	messageIDHeader string
+	producerID      string
+	sequence        atomic.Uint64
+
+	// buffer holds frames sent while reconnecting, if enabled.
+	buffer *sendBuffer
+
+	// outboxStop stops forwarding the messages of outbox.
+	outbox     *Outbox
+	outboxStop chan struct{}
+
+	// mu guards the connection state against concurrent Close and
+	// reconnect attempts.
+	mu           sync.Mutex
+	closed       bool
+	reconnecting bool
+
+	// loops tracks the goroutines serving the current network connection.
+	loops sync.WaitGroup
 
 	closeC chan struct{}
 	writeC chan frame
-	readC  chan int
 }
 
+var (
+	// ErrClosed is returned when using a closed connection.
+	ErrClosed = errors.New("stomp: connection closed")
+
+	// ErrUnsubscribed is returned by Subscription.Err after the
+	// subscription was removed with Unsubscribe.
+	ErrUnsubscribed = errors.New("stomp: unsubscribed")
+)
+
 // A Subscription represents a subscription on a STOMP server to
 // a specified destination.
 type Subscription struct {
@@ -75,63 +121,322 @@ type Subscription struct {
 	Destination string
 
 	// C is the channel where messages received for this subscription
-	// are sent to.
+	// are sent to. It is closed when the subscription ends.
 	C chan *Message
 
 	id          string
 	destination string
+	ack         AckMode
 	options     []Option
+	conn        *Conn
+	state       *subscriptionState
+}
+
+// subscriptionState tracks the end of a subscription. It is shared by the
+// subscriptions derived from it.
+type subscriptionState struct {
+	once sync.Once
+	done chan struct{}
+	err  error
+
+	// mu is held while a message is delivered to the channel of the
+	// subscription, so the channel is not closed during a send.
+	mu sync.Mutex
+}
+
+// end marks the subscription as ended because of err.
+func (s *subscriptionState) end(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+// close ends s with err and closes its channel. Ending s first releases a
+// delivery blocked on a full channel.
+func (s *Subscription) close(err error) {
+	s.state.end(err)
+	s.state.mu.Lock()
+	close(s.C)
+	s.conn.metrics.SubscriptionEnded(s.id, s.destination)
+	s.state.mu.Unlock()
+}
+
+// derive returns a subscription sharing the server side subscription of s,
+// but with its own channel to deliver messages derived from those of s.
+func (s *Subscription) derive() *Subscription {
+	return &Subscription{
+		Destination: s.Destination,
+		C:           make(chan *Message),
+		id:          s.id,
+		destination: s.destination,
+		ack:         s.ack,
+		options:     s.options,
+		conn:        s.conn,
+		state:       s.state,
+	}
+}
+
+// ID returns the identifier of the subscription, which is sent in the
+// subscription header of its messages.
+func (s *Subscription) ID() string {
+	return s.id
+}
+
+// Unsubscribe removes the subscription, see Conn.Unsubscribe.
+func (s *Subscription) Unsubscribe(options ...Option) error {
+	return s.conn.Unsubscribe(s, options...)
+}
+
+// Done returns a channel that is closed when the subscription ends, either
+// because it was removed with Unsubscribe or because the connection was
+// closed.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.state.done
+}
+
+// Err returns nil while the subscription is active. After Done is closed,
+// it returns ErrUnsubscribed if the subscription was removed with
+// Unsubscribe, or why the connection was closed.
+func (s *Subscription) Err() error {
+	select {
+	case <-s.state.done:
+		return s.state.err
+	default:
+		return nil
+	}
+}
+
+// A Dialer contains options for connecting to a STOMP server.
+//
+// The zero value for each field is equivalent to dialing without that option.
+// Dialing with the zero value of Dialer is therefore equivalent to just
+// calling the Dial function.
+type Dialer struct {
+	// NetDial specifies the dial function for creating network connections.
+	// It is used for the initial connection as well as for reconnects. If
+	// NetDial is nil, net.Dial is used.
+	NetDial func(network, addr string) (net.Conn, error)
+
+	// Reconnect and ReconnectSuccess initialize the fields of the same name
+	// of the connection. Unlike setting the fields after Dial returned,
+	// they are guaranteed to be in place before the connection can fail.
+	// If Reconnect is nil, ExponentialBackoffReconnect is used.
+	Reconnect        func(n int, d time.Duration, err error) (bool, time.Duration)
+	ReconnectSuccess func(n int)
+
+	// Strict enables strict parsing of frames received from the server.
+	// See Decoder for the differences to the default lenient parsing.
+	Strict bool
+
+	// HeartBeatGrace is the factor applied to the negotiated heart-beat
+	// intervals to tolerate network latency. A heart-beat from the server
+	// counts as missed if no data arrived within the interval times the
+	// grace. If zero, a grace of 2 is used.
+	HeartBeatGrace float64
+
+	// HeartBeatMisses is the number of heart-beats from the server that can
+	// be missed in a row before the connection is considered dead and
+	// reconnected. If zero, the first missed heart-beat fails the
+	// connection.
+	HeartBeatMisses int
+
+	// Logger receives structured events about the connection, such as
+	// connects, reconnect attempts, ERROR frames and slow consumers. If
+	// nil, nothing is logged.
+	Logger *slog.Logger
+
+	// Metrics receives measurements of the connection, such as the number
+	// of frames sent and received. If nil, nothing is measured.
+	Metrics Metrics
+
+	// Outbound interceptors are applied in order to every frame sent on
+	// the connection after its options, including the frames sent when
+	// reconnecting. Inbound interceptors are applied in order to every
+	// frame received after the connection was established, before it is
+	// processed. Heart-beats are not intercepted.
+	Outbound []Interceptor
+	Inbound  []Interceptor
+
+	// OnReject, if non-nil, is called with each received frame rejected
+	// by an inbound interceptor or failing decompression and the error it
+	// was rejected with, e.g. a *stompcrypto.TamperError. It is called by
+	// the goroutine reading from the connection, so it must not block.
+	OnReject func(f *Frame, err error)
+
+	// DisableDecompression disables the automatic decompression of message
+	// bodies with a registered content-encoding, see Compress.
+	DisableDecompression bool
+
+	// MaxDecompressedSize is the largest size in octets a received body is
+	// decompressed to. Larger messages are rejected like messages failing
+	// an inbound interceptor. If zero, DefaultMaxDecompressedSize is used.
+	MaxDecompressedSize int
+
+	// StreamThreshold, if positive, enables streaming of message bodies
+	// with a content-length of at least StreamThreshold octets. They are
+	// not buffered in memory but read from the connection through
+	// Message.BodyReader. Inbound interceptors see these messages without
+	// body and they are not decompressed.
+	StreamThreshold int64
+
+	// Outbox, if non-nil, makes Send and SendValue append messages to the
+	// outbox instead of sending them directly. The connection forwards the
+	// messages of the outbox to the server until it is closed. SendStream
+	// bypasses the outbox if the size of the body is known.
+	Outbox *Outbox
+
+	// ReconnectBuffer is the number of messages that can be sent while the
+	// connection is re-established. They are sent in order once the
+	// connection is up again, and the senders block until then. If zero,
+	// sending fails while reconnecting. Other frames, such as ACK, always
+	// fail as they refer to the lost session.
+	ReconnectBuffer int
+
+	// ReconnectOverflow determines what happens to messages sent while the
+	// reconnect buffer is full.
+	ReconnectOverflow OverflowPolicy
+
+	// ReconnectTTL, if positive, is how long a message can wait in the
+	// reconnect buffer before its sender fails with ErrBufferExpired.
+	ReconnectTTL time.Duration
+
+	// MessageIDHeader, if not empty, is the header stamped on every
+	// message with an ID unique to the connection followed by a sequence
+	// number, e.g. "_AMQ_DUPL_ID" for the duplicate detection of ActiveMQ
+	// Artemis. A message keeps its ID when it is resent from the reconnect
+	// buffer or the outbox, but a caller retrying Send gets a new ID; set
+	// the header explicitly to keep it across such retries. Messages
+	// already carrying the header are not changed.
+	MessageIDHeader string
 }
 
 // Dial connects to the given network address using net.Dial an then initializes
 // a STOMP connection. Additional header and options can be given via the
 // options parameter.
 func Dial(network, addr string, options ...Option) (*Conn, error) {
-	conn, err := net.Dial(network, addr)
+	var d Dialer
+	return d.Dial(network, addr, options...)
+}
+
+// Dial connects to the given network address using the dial function of the
+// dialer and then initializes a STOMP connection. Additional header and
+// options can be given via the options parameter.
+func (d *Dialer) Dial(network, addr string, options ...Option) (*Conn, error) {
+	dial := d.NetDial
+	if dial == nil {
+		dial = net.Dial
+	}
+
+	conn, err := dial(network, addr)
 	if err != nil {
 		return nil, err
 	}
 
+	reconnect := d.Reconnect
+	if reconnect == nil {
+		reconnect = ExponentialBackoffReconnect
+	}
+
+	logger := d.Logger
+	if logger == nil {
+		logger = discardLogger
+	}
+
+	var metrics Metrics = nopMetrics{}
+	if d.Metrics != nil {
+		metrics = d.Metrics
+	}
+
+	maxDecompressedSize := d.MaxDecompressedSize
+	if maxDecompressedSize <= 0 {
+		maxDecompressedSize = DefaultMaxDecompressedSize
+	}
+
 	c := &Conn{
 		Err:              nil,
-		Reconnect:        ExponentialBackoffReconnect,
-		ReconnectSuccess: nil,
+		Reconnect:        reconnect,
+		ReconnectSuccess: d.ReconnectSuccess,
 
 		conn:    conn,
 		network: network,
 		addr:    addr,
 		options: options,
-
-		reader: bufio.NewReader(conn),
-		subs:   make(map[string]*Subscription),
-
-		rhb: 5 * time.Second,
-		whb: 5 * time.Second,
-
-		closeC: make(chan struct{}),
-		writeC: make(chan frame),
-		readC:  make(chan int, 1),
+		dial:    dial,
+		strict:  d.Strict,
+
+		decoder: &Decoder{Strict: d.Strict, reader: bufio.NewReader(conn), stream: d.StreamThreshold},
+		subs:    make(map[string]*Subscription),
+
+		heartbeat: newHeartbeatMonitor(d.HeartBeatGrace, d.HeartBeatMisses),
+		logger:    logger,
+		metrics:   metrics,
+
+		outboundInterceptors: d.Outbound,
+		inboundInterceptors:  d.Inbound,
+		onReject:             d.OnReject,
+		disableDecompression: d.DisableDecompression,
+		maxDecompressedSize:  maxDecompressedSize,
+		streamThreshold:      d.StreamThreshold,
+		outbox:               d.Outbox,
+		buffer:               newSendBuffer(d.ReconnectBuffer, d.ReconnectOverflow, d.ReconnectTTL),
+		messageIDHeader:      d.MessageIDHeader,
+		producerID:           randID(),
 	}
 
 	err = c.connect(options)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 
-	go c.readLoop()
-	go c.writeLoop()
+	c.mu.Lock()
+	c.start()
+	c.mu.Unlock()
+
+	if c.outbox != nil {
+		c.outboxStop = make(chan struct{})
+		go c.outbox.forward(c, c.outboxStop)
+	}
 	return c, nil
 }
 
+// start starts the read and write loops serving the current network
+// connection. The loops stop when closeC is closed. The caller must hold
+// c.mu.
+func (c *Conn) start() {
+	c.closeC = make(chan struct{})
+	c.writeC = make(chan frame)
+
+	frames := make(chan *Frame)
+	errC := make(chan error, 1)
+
+	c.loops.Add(3)
+	go c.readFrames(c.closeC, frames, errC)
+	go c.readLoop(c.closeC, frames, errC)
+	go c.writeLoop(c.closeC, c.writeC)
+}
+
 func (c *Conn) connect(options []Option) error {
-	err := c.unsafeWrite(&Frame{
+	connect := &Frame{
 		Command: "CONNECT",
 		Header: Header{
 			"host":           "localhost",
 			"accept-version": "1.2",
-			"heart-beat":     fmt.Sprintf("%d,%d", c.whb/time.Millisecond, c.rhb/time.Millisecond),
+			"heart-beat":     fmt.Sprintf("%d,%d", defaultHeartBeat/time.Millisecond, defaultHeartBeat/time.Millisecond),
 		},
-	}, options...)
+	}
+
+	c.heartbeat.reset()
+	c.receipts.reset()
+	ok, err := c.outbound(connect, options)
+	if err != nil {
+		return err
+	} else if !ok {
+		return errors.New("stomp: CONNECT frame dropped by interceptor")
+	}
+
+	err = c.unsafeWrite(connect)
 	if err != nil {
 		return err
 	}
@@ -145,25 +450,64 @@ func (c *Conn) connect(options []Option) error {
 		return NewError(f)
 	}
 
-	// parse connected frame and store heart beat
-	connected := &Connected{*f}
-	c.rhb = time.Duration(connected.ReadHeartBeat()) * time.Millisecond
-	c.whb = time.Duration(connected.WriteHeartBeat()) * time.Millisecond
+	if f.Command != "CONNECTED" {
+		return fmt.Errorf("stomp: expected CONNECTED frame, got %q", f.Command)
+	}
+
+	// the options may have changed the heart-beat header of the CONNECT frame
+	c.heartbeat.negotiate(connect.Header["heart-beat"], f.Header["heart-beat"])
+
+	hb := c.heartbeat.state()
+	c.logger.Info("stomp: connected",
+		"addr", c.addr,
+		"version", f.Header["version"],
+		"server", f.Header["server"],
+		slog.Group("heartbeat", "send", hb.Send, "recv", hb.Recv))
 	return nil
 }
 
+// HeartBeat returns the negotiated heart-beating of the connection.
+func (c *Conn) HeartBeat() HeartBeat {
+	return c.heartbeat.state()
+}
+
 // Close closes the connection and all associated subscription channels.
 func (c *Conn) Close() error {
-	log.Printf("DEBUG: closing ...")
-	close(c.closeC)
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.logger.Debug("stomp: closing connection", "addr", c.addr)
+	if c.outboxStop != nil {
+		close(c.outboxStop)
+	}
+	conn, reconnecting := c.conn, c.reconnecting
+	if !reconnecting {
+		close(c.closeC)
+	}
+	c.mu.Unlock()
+
+	c.buffer.fail(ErrClosed)
 	c.closeSubscriptions()
-	return c.conn.Close()
+	if reconnecting {
+		// the loops are already stopped and the broken connection closed
+		conn.Close()
+		return nil
+	}
+	return conn.Close()
 }
 
 func (c *Conn) closeSubscriptions() {
+	err := c.Err
+	if err == nil {
+		err = ErrClosed
+	}
+
 	c.subsMu.Lock()
 	for _, sub := range c.subs {
-		close(sub.C)
+		sub.close(err)
 	}
 
 	c.subs = make(map[string]*Subscription)
@@ -184,41 +528,74 @@ func (c *Conn) Subscribe(destination string, options ...Option) (*Subscription,
 		},
 	}
 	sub := &Subscription{
+		Destination: destination,
 		C:           make(chan *Message, 10),
 		id:          id,
 		destination: destination,
+		ack:         ackMode(frame, options),
 		options:     options,
+		conn:        c,
+		state:       &subscriptionState{done: make(chan struct{})},
 	}
 
-	if err := c.safeWrite(frame, options...); err != nil {
-		return nil, err
-	}
-
+	// register the subscription first, the server may send messages as
+	// soon as it has received the frame
 	c.subsMu.Lock()
 	c.subs[id] = sub
 	c.subsMu.Unlock()
 
+	if err := c.safeWrite(frame, options...); err != nil {
+		c.subsMu.Lock()
+		_, ok := c.subs[id]
+		delete(c.subs, id)
+		c.subsMu.Unlock()
+		if ok {
+			sub.close(err)
+		}
+		return nil, err
+	}
+
+	if _, ok := c.metrics.(nopMetrics); !ok {
+		go c.reportQueueDepth(sub)
+	}
 	return sub, nil
 }
 
+// ackMode returns the ack mode the SUBSCRIBE frame f has after applying the
+// options, without changing f.
+func ackMode(f *Frame, options []Option) AckMode {
+	probe := &Frame{Command: f.Command, Header: maps.Clone(f.Header)}
+	for _, fn := range options {
+		fn(probe)
+	}
+	return AckMode(probe.Header["ack"])
+}
+
 // Unsubscribe removes an existing subscription and closes the receiver channel.
 // Once the subscription is removed the STOMP connections will no longer receive
 // messages from that subscription.
+//
+// The subscription is removed even if the UNSUBSCRIBE frame cannot be sent,
+// so it is not renewed when reconnecting. Unsubscribing an ended
+// subscription does nothing.
 func (c *Conn) Unsubscribe(s *Subscription, options ...Option) error {
-	frame := &Frame{
-		Command: "UNSUBSCRIBE",
-		Header:  Header{"id": s.id},
+	c.subsMu.Lock()
+	sub, ok := c.subs[s.id]
+	if ok {
+		delete(c.subs, s.id)
 	}
+	c.subsMu.Unlock()
 
-	if err := c.safeWrite(frame, options...); err != nil {
-		return err
+	if !ok {
+		return nil
 	}
+	sub.close(ErrUnsubscribed)
 
-	c.subsMu.Lock()
-	delete(c.subs, s.id)
-	c.subsMu.Unlock()
-
-	return nil
+	frame := &Frame{
+		Command: "UNSUBSCRIBE",
+		Header:  Header{"id": s.id},
+	}
+	return c.safeWrite(frame, options...)
 }
 
 // Send sends a message to a destination in the messaging system.
@@ -232,7 +609,15 @@ func (c *Conn) Unsubscribe(s *Subscription, options ...Option) error {
 // depend on the destination value being used and the other message headers
 // such as the "transaction" or "persist" header or other server specific
 // message headers.
+//
+// If the connection was dialed with an Outbox, the message is appended to the
+// outbox and sent by the connection later.
 func (c *Conn) Send(destination, contentType string, body []byte, options ...Option) error {
+	if c.outbox != nil {
+		// the ID is stored with the message in the outbox
+		return c.outbox.Send(destination, contentType, body, append(options[:len(options):len(options)], c.stampMessageID)...)
+	}
+
 	frame := &Frame{
 		Command: "SEND",
 		Header: Header{
@@ -254,14 +639,7 @@ func (c *Conn) Send(destination, contentType string, body []byte, options ...Opt
 // such a subscription will not be considered to have been consumed until the
 // message has been acknowledged via Ack.
 func (c *Conn) Ack(m *Message, options ...Option) error {
-	id := m.Ack()
-	if id != "" {
-		return c.safeWrite(&Frame{
-			Command: "ACK",
-			Header:  Header{"id": id},
-		}, options...)
-	}
-	return nil
+	return c.acknowledge("ACK", m, options)
 }
 
 // Nack is the opposite of Ack. It tells the server that the client did not
@@ -273,22 +651,28 @@ func (c *Conn) Ack(m *Message, options ...Option) error {
 // client-individual) or to all messages sent before and not yet Ack'ed or
 // Nack'ed (if the subscription's ack mode is client).
 func (c *Conn) Nack(m *Message, options ...Option) error {
-	id := m.Ack()
-	if id != "" {
-		return c.safeWrite(&Frame{
-			Command: "NACK",
-			Header:  Header{"id": id},
-		}, options...)
-	}
-	return nil
+	return c.acknowledge("NACK", m, options)
 }
 
-var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+// acknowledge sends an ACK or NACK frame for m, or for each of its chunks if
+// it was reassembled from chunks.
+func (c *Conn) acknowledge(command string, m *Message, options []Option) error {
+	ids := m.acks
+	if ids == nil {
+		ids = []string{m.AckID()}
+	}
 
-func randID() string {
-	b := make([]rune, 8)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		err := c.safeWrite(&Frame{
+			Command: command,
+			Header:  Header{"id": id},
+		}, options...)
+		if err != nil {
+			return err
+		}
 	}
-	return string(b)
+	return nil
 }
@@ -0,0 +1,219 @@
+// Package stompcrypto provides end-to-end encryption and signing of message
+// bodies as interceptors for stomp connections.
+//
+// Bodies of SEND frames are encrypted with AES-GCM and signed with
+// HMAC-SHA256 or Ed25519 by outbound interceptors. The IDs of the keys used
+// are sent in headers, so keys can be rotated with a KeyProvider while
+// messages encrypted with older keys are still in flight. Inbound
+// interceptors verify and decrypt received messages before they are
+// delivered to subscriptions. Messages failing verification or decryption
+// are rejected with a *TamperError, which is passed to the OnReject callback
+// of the stomp.Dialer. Signatures cover the destination as well
+// as the body and the headers describing it, so a signed message cannot be
+// replayed to another destination.
+//
+//	keys := stompcrypto.NewKeyRing()
+//	keys.Rotate("2024-01", key)
+//
+//	d := &stomp.Dialer{
+//		Outbound: []stomp.Interceptor{stompcrypto.Encrypt(keys), stompcrypto.SignHMAC(keys)},
+//		Inbound:  []stomp.Interceptor{stompcrypto.VerifyHMAC(keys), stompcrypto.Decrypt(keys)},
+//	}
+//
+// Encryption should happen before signing, so verification happens before
+// decryption.
+package stompcrypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/cumulodev/stomp"
+)
+
+// Header names used to describe encrypted bodies.
+const (
+	EncryptionHeader      = "encryption"
+	EncryptionKeyIDHeader = "encryption-key-id"
+)
+
+// encryptionAlgorithm is the value of the encryption header.
+const encryptionAlgorithm = "aes-gcm"
+
+// ErrTampered is matched by all errors reporting a message that failed
+// verification or decryption. Use errors.Is to test for it.
+var ErrTampered = errors.New("stompcrypto: message tampered")
+
+// A TamperError reports a received message that was modified, corrupted or
+// protected with an unknown key.
+type TamperError struct {
+	// MessageID is the message-id header of the rejected message.
+	MessageID string
+
+	// Reason describes why the message was rejected.
+	Reason string
+}
+
+func (e *TamperError) Error() string {
+	return fmt.Sprintf("stompcrypto: message %q rejected: %s", e.MessageID, e.Reason)
+}
+
+// Is reports whether target is ErrTampered.
+func (e *TamperError) Is(target error) bool {
+	return target == ErrTampered
+}
+
+// A KeyProvider supplies the keys for encryption or signing.
+type KeyProvider interface {
+	// CurrentKey returns the key used to protect new messages and its ID.
+	CurrentKey() (id string, key []byte, err error)
+
+	// Key returns the key with the given ID to unprotect received
+	// messages.
+	Key(id string) ([]byte, error)
+}
+
+// A KeyRing is a KeyProvider holding keys in memory. It is safe for
+// concurrent use, so keys can be rotated while connections are using it.
+type KeyRing struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyRing returns an empty KeyRing.
+func NewKeyRing() *KeyRing {
+	return &KeyRing{keys: make(map[string][]byte)}
+}
+
+// Rotate adds a key and makes it the current key. Previous keys remain
+// available for received messages until they are removed.
+func (r *KeyRing) Rotate(id string, key []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[id] = key
+	r.current = id
+}
+
+// Remove removes the key with the given ID.
+func (r *KeyRing) Remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.keys, id)
+	if r.current == id {
+		r.current = ""
+	}
+}
+
+// CurrentKey implements the KeyProvider interface.
+func (r *KeyRing) CurrentKey() (string, []byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.current == "" {
+		return "", nil, errors.New("stompcrypto: no current key")
+	}
+	return r.current, r.keys[r.current], nil
+}
+
+// Key implements the KeyProvider interface.
+func (r *KeyRing) Key(id string) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("stompcrypto: unknown key %q", id)
+	}
+	return key, nil
+}
+
+// Encrypt returns an outbound interceptor encrypting the bodies of SEND
+// frames with AES-GCM using the current key of keys, which must be 16, 24
+// or 32 octets long to select AES-128, AES-192 or AES-256.
+func Encrypt(keys KeyProvider) stomp.Interceptor {
+	return func(f *stomp.Frame) error {
+		if f.Command != "SEND" {
+			return nil
+		}
+
+		id, key, err := keys.CurrentKey()
+		if err != nil {
+			return err
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return err
+		}
+
+		nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(f.Body)+aead.Overhead())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+
+		f.Body = aead.Seal(nonce, nonce, f.Body, []byte(id))
+		f.Header[EncryptionHeader] = encryptionAlgorithm
+		f.Header[EncryptionKeyIDHeader] = id
+		return nil
+	}
+}
+
+// Decrypt returns an inbound interceptor decrypting the bodies of messages
+// encrypted by Encrypt with a key of keys. Messages without encryption
+// header are passed unchanged.
+func Decrypt(keys KeyProvider) stomp.Interceptor {
+	return func(f *stomp.Frame) error {
+		if f.Command != "MESSAGE" || f.Header[EncryptionHeader] == "" {
+			return nil
+		}
+
+		tampered := func(reason string) error {
+			return &TamperError{MessageID: f.Header["message-id"], Reason: reason}
+		}
+
+		if f.Header[EncryptionHeader] != encryptionAlgorithm {
+			return tampered(fmt.Sprintf("unsupported encryption %q", f.Header[EncryptionHeader]))
+		}
+
+		id := f.Header[EncryptionKeyIDHeader]
+		key, err := keys.Key(id)
+		if err != nil {
+			return tampered(err.Error())
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return err
+		}
+
+		if len(f.Body) < aead.NonceSize() {
+			return tampered("ciphertext too short")
+		}
+
+		nonce, ciphertext := f.Body[:aead.NonceSize()], f.Body[aead.NonceSize():]
+		body, err := aead.Open(nil, nonce, ciphertext, []byte(id))
+		if err != nil {
+			return tampered("decryption failed")
+		}
+
+		f.Body = body
+		if _, ok := f.Header["content-length"]; ok {
+			f.Header["content-length"] = strconv.Itoa(len(body))
+		}
+		delete(f.Header, EncryptionHeader)
+		delete(f.Header, EncryptionKeyIDHeader)
+		return nil
+	}
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,177 @@
+package stompcrypto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"github.com/cumulodev/stomp/stomptest"
+)
+
+func TestEncryptSign(t *testing.T) {
+	keys := NewKeyRing()
+	keys.Rotate("k1", bytes.Repeat([]byte{1}, 32))
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signing, verifying := NewKeyRing(), NewKeyRing()
+	signing.Rotate("s1", priv)
+	verifying.Rotate("s1", pub)
+
+	modes := []struct {
+		name         string
+		sign, verify stomp.Interceptor
+	}{
+		{"hmac", SignHMAC(keys), VerifyHMAC(keys)},
+		{"ed25519", SignEd25519(signing), VerifyEd25519(verifying)},
+	}
+
+	for _, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			// send encrypts and signs a body and returns it as received
+			send := func(body string) *stomp.Frame {
+				f := &stomp.Frame{
+					Command: "SEND",
+					Header:  stomp.Header{"destination": "/queue/test", "content-type": "text/plain"},
+					Body:    []byte(body),
+				}
+				for _, fn := range []stomp.Interceptor{Encrypt(keys), mode.sign} {
+					if err := fn(f); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if bytes.Contains(f.Body, []byte(body)) {
+					t.Fatal("body sent in plaintext")
+				}
+				f.Command = "MESSAGE"
+				f.Header["message-id"] = "1"
+				f.Header["content-length"] = strconv.Itoa(len(f.Body))
+				return f
+			}
+
+			receive := func(f *stomp.Frame) error {
+				for _, fn := range []stomp.Interceptor{mode.verify, Decrypt(keys)} {
+					if err := fn(f); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+
+			f := send("hello")
+			keys.Rotate("k2", bytes.Repeat([]byte{2}, 16))
+			defer keys.Rotate("k1", bytes.Repeat([]byte{1}, 32))
+
+			// messages encrypted before the rotation are still readable
+			for _, f := range []*stomp.Frame{f, send("hello")} {
+				if err := receive(f); err != nil {
+					t.Fatal(err)
+				}
+				if string(f.Body) != "hello" {
+					t.Errorf("got body %q, want %q", f.Body, "hello")
+				}
+				if len(f.Header) != 4 {
+					t.Errorf("got header %v, want protection headers removed", f.Header)
+				}
+				if n := f.Header["content-length"]; n != "5" {
+					t.Errorf("got content-length %q, want %q", n, "5")
+				}
+			}
+
+			tampers := map[string]func(f *stomp.Frame){
+				"body":         func(f *stomp.Frame) { f.Body[len(f.Body)-1] ^= 1 },
+				"content-type": func(f *stomp.Frame) { f.Header["content-type"] = "text/html" },
+				"destination":  func(f *stomp.Frame) { f.Header["destination"] = "/queue/other" },
+				"key":          func(f *stomp.Frame) { f.Header[EncryptionKeyIDHeader] = "k1" },
+				"signature":    func(f *stomp.Frame) { delete(f.Header, SignatureHeader) },
+				"unsigned":     func(f *stomp.Frame) { delete(f.Header, SignatureAlgorithmHeader) },
+				"unknown key":  func(f *stomp.Frame) { f.Header[SignatureKeyIDHeader] = "s0" },
+			}
+			for name, tamper := range tampers {
+				f := send("hello")
+				tamper(f)
+
+				err := receive(f)
+				var terr *TamperError
+				if !errors.As(err, &terr) || !errors.Is(err, ErrTampered) || terr.MessageID != "1" {
+					t.Errorf("%s: got error %v, want TamperError", name, err)
+				}
+			}
+		})
+	}
+
+	// a missing signature is detected even if the body decrypts
+	f := &stomp.Frame{Command: "SEND", Header: stomp.Header{}, Body: []byte("hello")}
+	if err := Encrypt(keys)(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Command = "MESSAGE"
+	if err := VerifyHMAC(keys)(f); !errors.Is(err, ErrTampered) {
+		t.Errorf("got error %v for unsigned message, want ErrTampered", err)
+	}
+	if err := Decrypt(keys)(f); err != nil || string(f.Body) != "hello" {
+		t.Errorf("got %q, %v, want decrypted body", f.Body, err)
+	}
+}
+
+func TestOnReject(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	keys := NewKeyRing()
+	keys.Rotate("k1", bytes.Repeat([]byte{1}, 32))
+
+	rejected := make(chan error, 1)
+	d := &stomp.Dialer{
+		Outbound: []stomp.Interceptor{Encrypt(keys), SignHMAC(keys)},
+		Inbound:  []stomp.Interceptor{VerifyHMAC(keys), Decrypt(keys)},
+		OnReject: func(f *stomp.Frame, err error) {
+			rejected <- err
+		},
+	}
+	conn, err := d.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.Subscribe("/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WaitFrames("SUBSCRIBE", 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// a message published without signature is rejected
+	s.Publish("/queue/test", []byte("forged"), nil)
+	if err := conn.Send("/queue/test", "text/plain", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-sub.C:
+		if string(msg.Body) != "hello" {
+			t.Errorf("got message %q, want %q", msg.Body, "hello")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+
+	select {
+	case err := <-rejected:
+		var terr *TamperError
+		if !errors.As(err, &terr) || terr.MessageID == "" {
+			t.Errorf("got error %v, want TamperError", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for rejection")
+	}
+}
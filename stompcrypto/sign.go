@@ -0,0 +1,161 @@
+package stompcrypto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/cumulodev/stomp"
+)
+
+// Header names used to describe signed messages.
+const (
+	SignatureHeader          = "signature"
+	SignatureAlgorithmHeader = "signature-algorithm"
+	SignatureKeyIDHeader     = "signature-key-id"
+)
+
+// encoding encodes signatures in header values.
+var encoding = base64.RawStdEncoding
+
+// signedHeaders are covered by the signature in addition to the body.
+var signedHeaders = []string{
+	"destination",
+	"content-type",
+	"content-encoding",
+	EncryptionHeader,
+	EncryptionKeyIDHeader,
+	SignatureAlgorithmHeader,
+	SignatureKeyIDHeader,
+}
+
+// A signer signs and verifies data with a key.
+type signer struct {
+	algorithm string
+	sign      func(key, data []byte) ([]byte, error)
+	verify    func(key, data, sig []byte) bool
+}
+
+var hmacSHA256 = signer{
+	algorithm: "hmac-sha256",
+	sign: func(key, data []byte) ([]byte, error) {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(data)
+		return mac.Sum(nil), nil
+	},
+	verify: func(key, data, sig []byte) bool {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(data)
+		return hmac.Equal(mac.Sum(nil), sig)
+	},
+}
+
+var ed25519Signer = signer{
+	algorithm: "ed25519",
+	sign: func(key, data []byte) ([]byte, error) {
+		if len(key) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("stompcrypto: invalid ed25519 private key size %d", len(key))
+		}
+		return ed25519.Sign(ed25519.PrivateKey(key), data), nil
+	},
+	verify: func(key, data, sig []byte) bool {
+		return len(key) == ed25519.PublicKeySize && ed25519.Verify(ed25519.PublicKey(key), data, sig)
+	},
+}
+
+// SignHMAC returns an outbound interceptor signing SEND frames with
+// HMAC-SHA256 using the current key of keys.
+func SignHMAC(keys KeyProvider) stomp.Interceptor {
+	return hmacSHA256.signInterceptor(keys)
+}
+
+// VerifyHMAC returns an inbound interceptor rejecting messages without a
+// valid HMAC-SHA256 signature made with a key of keys.
+func VerifyHMAC(keys KeyProvider) stomp.Interceptor {
+	return hmacSHA256.verifyInterceptor(keys)
+}
+
+// SignEd25519 returns an outbound interceptor signing SEND frames with
+// Ed25519. The current key of keys must be an ed25519.PrivateKey.
+func SignEd25519(keys KeyProvider) stomp.Interceptor {
+	return ed25519Signer.signInterceptor(keys)
+}
+
+// VerifyEd25519 returns an inbound interceptor rejecting messages without a
+// valid Ed25519 signature. The keys of keys must be ed25519.PublicKeys with
+// the IDs of the corresponding private keys used by the sender.
+func VerifyEd25519(keys KeyProvider) stomp.Interceptor {
+	return ed25519Signer.verifyInterceptor(keys)
+}
+
+func (s signer) signInterceptor(keys KeyProvider) stomp.Interceptor {
+	return func(f *stomp.Frame) error {
+		if f.Command != "SEND" {
+			return nil
+		}
+
+		id, key, err := keys.CurrentKey()
+		if err != nil {
+			return err
+		}
+
+		f.Header[SignatureAlgorithmHeader] = s.algorithm
+		f.Header[SignatureKeyIDHeader] = id
+		sig, err := s.sign(key, signedData(f))
+		if err != nil {
+			return err
+		}
+
+		f.Header[SignatureHeader] = encoding.EncodeToString(sig)
+		return nil
+	}
+}
+
+func (s signer) verifyInterceptor(keys KeyProvider) stomp.Interceptor {
+	return func(f *stomp.Frame) error {
+		if f.Command != "MESSAGE" {
+			return nil
+		}
+
+		tampered := func(reason string) error {
+			return &TamperError{MessageID: f.Header["message-id"], Reason: reason}
+		}
+
+		if f.Header[SignatureAlgorithmHeader] != s.algorithm {
+			return tampered(fmt.Sprintf("missing %s signature", s.algorithm))
+		}
+
+		sig, err := encoding.DecodeString(f.Header[SignatureHeader])
+		if err != nil {
+			return tampered("malformed signature")
+		}
+
+		key, err := keys.Key(f.Header[SignatureKeyIDHeader])
+		if err != nil {
+			return tampered(err.Error())
+		}
+
+		if !s.verify(key, signedData(f), sig) {
+			return tampered("invalid signature")
+		}
+
+		delete(f.Header, SignatureHeader)
+		delete(f.Header, SignatureAlgorithmHeader)
+		delete(f.Header, SignatureKeyIDHeader)
+		return nil
+	}
+}
+
+// signedData returns the canonical representation of the signed parts of f.
+func signedData(f *stomp.Frame) []byte {
+	var buf bytes.Buffer
+	for _, key := range signedHeaders {
+		fmt.Fprintf(&buf, "%s:%q\n", key, f.Header[key])
+	}
+	buf.WriteByte('\n')
+	buf.Write(f.Body)
+	return buf.Bytes()
+}
@@ -0,0 +1,38 @@
+// Package stompmsgpack provides a codec for MessagePack message bodies.
+// Importing the package registers the codec for the "application/msgpack"
+// content type:
+//
+//	import _ "github.com/cumulodev/stomp/stompmsgpack"
+//
+//	err := conn.SendValue("/queue/orders", order, stomp.ContentType(stompmsgpack.ContentType))
+package stompmsgpack
+
+import (
+	"github.com/cumulodev/stomp"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ContentType is the content type of MessagePack message bodies.
+const ContentType = "application/msgpack"
+
+func init() {
+	stomp.RegisterCodec(Codec{})
+}
+
+// Codec encodes values with the github.com/vmihailenco/msgpack/v5 package.
+type Codec struct{}
+
+// ContentType implements the stomp.Codec interface.
+func (Codec) ContentType() string {
+	return ContentType
+}
+
+// Marshal implements the stomp.Codec interface.
+func (Codec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+// Unmarshal implements the stomp.Codec interface.
+func (Codec) Unmarshal(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
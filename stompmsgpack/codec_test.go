@@ -0,0 +1,39 @@
+package stompmsgpack
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cumulodev/stomp"
+)
+
+func TestCodec(t *testing.T) {
+	type order struct {
+		ID    int
+		Items []string
+	}
+
+	codec, ok := stomp.CodecFor(ContentType)
+	if !ok {
+		t.Fatal("codec not registered")
+	}
+
+	want := order{ID: 42, Items: []string{"a", "b"}}
+	body, err := codec.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &stomp.Message{Frame: stomp.Frame{
+		Header: stomp.Header{"content-type": ContentType},
+		Body:   body,
+	}}
+
+	var got order
+	if err := msg.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
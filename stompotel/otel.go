@@ -0,0 +1,134 @@
+// Package stompotel propagates OpenTelemetry trace context through STOMP
+// messages and records producer and consumer spans following the messaging
+// semantic conventions.
+//
+// The trace context of the producer is injected into the headers of SEND
+// frames, by default as W3C "traceparent" and "tracestate" headers, and
+// extracted from the headers of received messages:
+//
+//	t := stompotel.NewTracer(nil, nil)
+//	err := t.Send(ctx, conn, "/queue/orders", "application/json", body)
+//
+//	for msg := range sub.C {
+//		ctx, span := t.StartProcess(context.Background(), msg)
+//		handle(ctx, msg)
+//		span.End()
+//	}
+package stompotel
+
+import (
+	"context"
+
+	"github.com/cumulodev/stomp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/cumulodev/stomp/stompotel"
+
+// A Tracer creates spans for STOMP messages and propagates their context.
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// NewTracer returns a Tracer creating spans with tp and propagating their
+// context with p. If tp is nil, the global TracerProvider is used. If p is
+// nil, the W3C Trace Context format is used.
+func NewTracer(tp trace.TracerProvider, p propagation.TextMapPropagator) *Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	if p == nil {
+		p = propagation.TraceContext{}
+	}
+
+	return &Tracer{
+		tracer:     tp.Tracer(instrumentationName),
+		propagator: p,
+	}
+}
+
+// Inject returns an option adding the trace context of ctx to the headers
+// of a frame.
+func (t *Tracer) Inject(ctx context.Context) stomp.Option {
+	return func(f *stomp.Frame) {
+		if f.Header == nil {
+			f.Header = make(stomp.Header)
+		}
+		t.propagator.Inject(ctx, headerCarrier(f.Header))
+	}
+}
+
+// Extract returns a copy of ctx carrying the trace context found in the
+// headers of msg.
+func (t *Tracer) Extract(ctx context.Context, msg *stomp.Message) context.Context {
+	return t.propagator.Extract(ctx, headerCarrier(msg.Header))
+}
+
+// Send sends a message like Conn.Send within a producer span, which is a
+// child of the span in ctx. The context of the producer span is injected
+// into the message headers.
+func (t *Tracer) Send(ctx context.Context, conn *stomp.Conn, destination, contentType string, body []byte, options ...stomp.Option) error {
+	ctx, span := t.tracer.Start(ctx, "send "+destination,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "stomp"),
+			attribute.String("messaging.operation.type", "send"),
+			attribute.String("messaging.operation.name", "send"),
+			attribute.String("messaging.destination.name", destination),
+			attribute.Int("messaging.message.body.size", len(body)),
+		))
+	defer span.End()
+
+	err := conn.Send(destination, contentType, body, append(options[:len(options):len(options)], t.Inject(ctx))...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// StartProcess starts a consumer span for processing msg. The span is linked
+// to the producer by the trace context extracted from the message headers.
+// The returned context carries the span, which the caller must end.
+func (t *Tracer) StartProcess(ctx context.Context, msg *stomp.Message) (context.Context, trace.Span) {
+	ctx = t.Extract(ctx, msg)
+
+	attrs := []attribute.KeyValue{
+		attribute.String("messaging.system", "stomp"),
+		attribute.String("messaging.operation.type", "process"),
+		attribute.String("messaging.operation.name", "process"),
+		attribute.String("messaging.destination.name", msg.Destination()),
+		attribute.String("messaging.destination.subscription.name", msg.Subscription()),
+		attribute.String("messaging.message.id", msg.Id()),
+		attribute.Int("messaging.message.body.size", len(msg.Body)),
+	}
+
+	return t.tracer.Start(ctx, "process "+msg.Destination(),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attrs...))
+}
+
+// headerCarrier adapts a frame header to the propagation.TextMapCarrier
+// interface.
+type headerCarrier stomp.Header
+
+func (c headerCarrier) Get(key string) string {
+	return c[key]
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,91 @@
+package stompotel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"github.com/cumulodev/stomp/stomptest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestPropagation(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer tp.Shutdown(context.Background())
+	tracer := NewTracer(tp, nil)
+
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.Subscribe("/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "request")
+	if err := tracer.Send(ctx, conn, "/queue/test", "text/plain", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	var msg *stomp.Message
+	select {
+	case msg = <-sub.C:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+
+	if msg.Header["traceparent"] == "" {
+		t.Fatalf("got header %v, want traceparent", msg.Header)
+	}
+
+	_, span := tracer.StartProcess(context.Background(), msg)
+	span.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+
+	producer, consumer := spans[0], spans[2]
+	if producer.SpanKind != trace.SpanKindProducer || producer.Name != "send /queue/test" {
+		t.Errorf("got producer span %q of kind %v", producer.Name, producer.SpanKind)
+	}
+	if consumer.SpanKind != trace.SpanKindConsumer || consumer.Name != "process /queue/test" {
+		t.Errorf("got consumer span %q of kind %v", consumer.Name, consumer.SpanKind)
+	}
+	if producer.Parent.SpanID() != spans[1].SpanContext.SpanID() {
+		t.Error("producer span is not a child of the request span")
+	}
+	if consumer.Parent.SpanID() != producer.SpanContext.SpanID() || consumer.Parent.TraceID() != producer.SpanContext.TraceID() {
+		t.Error("consumer span is not a child of the producer span")
+	}
+}
+
+func TestNoop(t *testing.T) {
+	tracer := NewTracer(noop.NewTracerProvider(), nil)
+
+	f := &stomp.Frame{Command: "SEND", Header: stomp.Header{"destination": "/queue/test"}}
+	tracer.Inject(context.Background())(f)
+	if len(f.Header) != 1 {
+		t.Errorf("got header %v without a span, want none added", f.Header)
+	}
+
+	msg := &stomp.Message{Frame: *f}
+	ctx, span := tracer.StartProcess(context.Background(), msg)
+	span.End()
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		t.Error("noop tracer created a valid span")
+	}
+}
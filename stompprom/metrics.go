@@ -0,0 +1,147 @@
+// Package stompprom exposes measurements of STOMP connections as Prometheus
+// collectors.
+//
+//	m, err := stompprom.New(prometheus.DefaultRegisterer)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	conn, err := (&stomp.Dialer{Metrics: m}).Dial("tcp", "localhost:61613")
+//
+// A single Metrics value can be shared by any number of connections.
+package stompprom
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics implements the stomp.Metrics interface using Prometheus
+// collectors. Heart-beats are counted with the command label "HEARTBEAT".
+type Metrics struct {
+	framesSent       *prometheus.CounterVec
+	framesReceived   *prometheus.CounterVec
+	bytesSent        prometheus.Counter
+	bytesReceived    prometheus.Counter
+	sendLatency      *prometheus.HistogramVec
+	receiptLatency   prometheus.Histogram
+	reconnects       prometheus.Counter
+	heartbeatsMissed prometheus.Counter
+	queueDepth       *prometheus.GaugeVec
+	dropped          *prometheus.CounterVec
+}
+
+// New creates the collectors of a Metrics and registers them on reg.
+func New(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		framesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "stomp_frames_sent_total",
+			Help: "Number of frames sent to the server by command.",
+		}, []string{"command"}),
+		framesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "stomp_frames_received_total",
+			Help: "Number of frames received from the server by command.",
+		}, []string{"command"}),
+		bytesSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "stomp_sent_bytes_total",
+			Help: "Number of octets sent to the server.",
+		}),
+		bytesReceived: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "stomp_received_bytes_total",
+			Help: "Number of octets received from the server.",
+		}),
+		sendLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "stomp_send_latency_seconds",
+			Help:    "Time it took to write a frame to the server by command.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"command"}),
+		receiptLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "stomp_receipt_latency_seconds",
+			Help:    "Time between sending a frame and receiving its receipt.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}),
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "stomp_reconnect_attempts_total",
+			Help: "Number of attempts to reconnect to the server.",
+		}),
+		heartbeatsMissed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "stomp_heartbeats_missed_total",
+			Help: "Number of heart-beats from the server that did not arrive in time.",
+		}),
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "stomp_subscription_queue_depth",
+			Help: "Number of messages buffered for a subscription by subscription ID and destination.",
+		}, []string{"subscription", "destination"}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "stomp_messages_dropped_total",
+			Help: "Number of messages dropped for unknown subscriptions by destination.",
+		}, []string{"destination"}),
+	}
+
+	for _, c := range []prometheus.Collector{
+		m.framesSent, m.framesReceived, m.bytesSent, m.bytesReceived,
+		m.sendLatency, m.receiptLatency, m.reconnects, m.heartbeatsMissed,
+		m.queueDepth, m.dropped,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func command(c string) string {
+	if c == "" {
+		return "HEARTBEAT"
+	}
+	return c
+}
+
+// FrameSent implements the stomp.Metrics interface.
+func (m *Metrics) FrameSent(cmd string, size int) {
+	m.framesSent.WithLabelValues(command(cmd)).Inc()
+	m.bytesSent.Add(float64(size))
+}
+
+// FrameReceived implements the stomp.Metrics interface.
+func (m *Metrics) FrameReceived(cmd string, size int) {
+	m.framesReceived.WithLabelValues(command(cmd)).Inc()
+	m.bytesReceived.Add(float64(size))
+}
+
+// SendLatency implements the stomp.Metrics interface.
+func (m *Metrics) SendLatency(cmd string, d time.Duration) {
+	m.sendLatency.WithLabelValues(command(cmd)).Observe(d.Seconds())
+}
+
+// ReceiptLatency implements the stomp.Metrics interface.
+func (m *Metrics) ReceiptLatency(d time.Duration) {
+	m.receiptLatency.Observe(d.Seconds())
+}
+
+// ReconnectAttempt implements the stomp.Metrics interface.
+func (m *Metrics) ReconnectAttempt() {
+	m.reconnects.Inc()
+}
+
+// HeartBeatMissed implements the stomp.Metrics interface.
+func (m *Metrics) HeartBeatMissed() {
+	m.heartbeatsMissed.Inc()
+}
+
+// QueueDepth implements the stomp.Metrics interface.
+func (m *Metrics) QueueDepth(subscription, destination string, depth int) {
+	m.queueDepth.WithLabelValues(subscription, destination).Set(float64(depth))
+}
+
+// SubscriptionEnded implements the stomp.Metrics interface. The queue depth
+// of the subscription is no longer exported.
+func (m *Metrics) SubscriptionEnded(subscription, destination string) {
+	m.queueDepth.DeleteLabelValues(subscription, destination)
+}
+
+// MessageDropped implements the stomp.Metrics interface.
+func (m *Metrics) MessageDropped(destination string) {
+	m.dropped.WithLabelValues(destination).Inc()
+}
@@ -0,0 +1,113 @@
+package stompprom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"github.com/cumulodev/stomp/stomptest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ stomp.Metrics = (*Metrics)(nil)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	m, err := New(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := (&stomp.Dialer{Metrics: m}).Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.Subscribe("/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Send("/queue/test", "text/plain", []byte("hello"), func(f *stomp.Frame) {
+		f.Header["receipt"] = "r1"
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-sub.C:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+
+	if n := testutil.ToFloat64(m.framesSent.WithLabelValues("SEND")); n != 1 {
+		t.Errorf("got %v SEND frames, want 1", n)
+	}
+	if n := testutil.ToFloat64(m.framesReceived.WithLabelValues("MESSAGE")); n != 1 {
+		t.Errorf("got %v MESSAGE frames, want 1", n)
+	}
+	if n := testutil.ToFloat64(m.bytesSent); n == 0 {
+		t.Error("sent octets not counted")
+	}
+	if n := testutil.CollectAndCount(m.queueDepth); n != 1 {
+		t.Errorf("got %d queue depth series, want 1", n)
+	}
+
+	// subscriptions to the same destination are measured separately
+	var subs []*stomp.Subscription
+	for range 2 {
+		sub, err := conn.Subscribe("/topic/test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs = append(subs, sub)
+	}
+	if _, err := s.WaitFrames("SUBSCRIBE", 3, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	s.Publish("/topic/test", []byte("hello"), nil)
+	for _, sub := range subs {
+		select {
+		case <-sub.C:
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for message")
+		}
+	}
+	if n := testutil.CollectAndCount(m.queueDepth); n != 3 {
+		t.Errorf("got %d queue depth series, want 3", n)
+	}
+
+	// drained queues are reported by the periodic sample
+	depth := m.queueDepth.WithLabelValues(sub.ID(), "/queue/test")
+	for deadline := time.Now().Add(3 * time.Second); testutil.ToFloat64(depth) != 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for drained queue depth")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// the series of ended subscriptions are deleted
+	if err := subs[0].Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.CollectAndCount(m.queueDepth); n != 2 {
+		t.Errorf("got %d queue depth series, want 2", n)
+	}
+
+	m.MessageDropped("/queue/test")
+	if n := testutil.ToFloat64(m.dropped.WithLabelValues("/queue/test")); n != 1 {
+		t.Errorf("got %v dropped messages, want 1", n)
+	}
+	if _, err := reg.Gather(); err != nil {
+		t.Error(err)
+	}
+
+	// a second registration of the same collectors fails
+	if _, err := New(reg); err == nil {
+		t.Error("registered collectors twice")
+	}
+}
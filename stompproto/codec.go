@@ -0,0 +1,48 @@
+// Package stompproto provides a codec for Protocol Buffers message bodies.
+// Importing the package registers the codec for the "application/x-protobuf"
+// content type:
+//
+//	import _ "github.com/cumulodev/stomp/stompproto"
+//
+//	err := conn.SendValue("/queue/orders", order, stomp.ContentType(stompproto.ContentType))
+package stompproto
+
+import (
+	"fmt"
+
+	"github.com/cumulodev/stomp"
+	"google.golang.org/protobuf/proto"
+)
+
+// ContentType is the content type of Protocol Buffers message bodies.
+const ContentType = "application/x-protobuf"
+
+func init() {
+	stomp.RegisterCodec(Codec{})
+}
+
+// Codec encodes values implementing proto.Message in the binary wire format.
+type Codec struct{}
+
+// ContentType implements the stomp.Codec interface.
+func (Codec) ContentType() string {
+	return ContentType
+}
+
+// Marshal implements the stomp.Codec interface.
+func (Codec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("stompproto: cannot marshal %T, not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+// Unmarshal implements the stomp.Codec interface.
+func (Codec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("stompproto: cannot unmarshal into %T, not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
@@ -0,0 +1,71 @@
+package stompproto
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"github.com/cumulodev/stomp/stomptest"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCodec(t *testing.T) {
+	codec, ok := stomp.CodecFor(ContentType)
+	if !ok {
+		t.Fatal("codec not registered")
+	}
+
+	body, err := codec.Marshal(wrapperspb.String("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &stomp.Message{Frame: stomp.Frame{
+		Header: stomp.Header{"content-type": ContentType},
+		Body:   body,
+	}}
+
+	var v wrapperspb.StringValue
+	if err := msg.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(&v, wrapperspb.String("hello")) {
+		t.Errorf("got %v, want %q", &v, "hello")
+	}
+
+	if _, err := codec.Marshal("hello"); err == nil {
+		t.Error("marshaled a value that is not a proto.Message")
+	}
+}
+
+func TestSubscribeTyped(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := stomp.SubscribeTyped[*wrapperspb.StringValue](conn, "/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SendValue("/queue/test", wrapperspb.String("hello"), stomp.ContentType(ContentType)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case env := <-sub.C:
+		if env.Err != nil {
+			t.Fatal(env.Err)
+		}
+		if !proto.Equal(env.Value, wrapperspb.String("hello")) {
+			t.Errorf("got %v, want %q", env.Value, "hello")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+}
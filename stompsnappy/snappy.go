@@ -0,0 +1,44 @@
+// Package stompsnappy provides a Snappy compressor for message bodies.
+// Importing the package registers it for the "snappy" content encoding:
+//
+//	import _ "github.com/cumulodev/stomp/stompsnappy"
+//
+//	err := conn.Send("/queue/docs", "application/json", body, stomp.Compress(stompsnappy.Encoding))
+package stompsnappy
+
+import (
+	"github.com/cumulodev/stomp"
+	"github.com/klauspost/compress/s2"
+)
+
+// Encoding is the content encoding of Snappy compressed bodies.
+const Encoding = "snappy"
+
+func init() {
+	stomp.RegisterCompressor(Compressor{})
+}
+
+// Compressor compresses bodies in the Snappy block format, which is
+// compatible with other Snappy implementations.
+type Compressor struct{}
+
+// Encoding implements the stomp.Compressor interface.
+func (Compressor) Encoding() string {
+	return Encoding
+}
+
+// Compress implements the stomp.Compressor interface.
+func (Compressor) Compress(data []byte) ([]byte, error) {
+	return s2.EncodeSnappy(nil, data), nil
+}
+
+// Decompress implements the stomp.Compressor interface.
+func (Compressor) Decompress(data []byte, max int) ([]byte, error) {
+	n, err := s2.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	} else if n > max {
+		return nil, stomp.ErrBodyTooLarge
+	}
+	return s2.Decode(nil, data)
+}
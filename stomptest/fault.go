@@ -0,0 +1,340 @@
+package stomptest
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrInjected is returned by a FaultConn operation interrupted by an injected
+// connection reset.
+var ErrInjected = errors.New("stomptest: injected connection reset")
+
+// Direction selects the data stream of a connection a Fault applies to.
+type Direction int
+
+const (
+	// Read is the stream of data received from the peer.
+	Read Direction = iota
+
+	// Write is the stream of data sent to the peer.
+	Write
+)
+
+// Action describes what happens when a Fault is triggered.
+type Action int
+
+const (
+	// Reset closes the connection abruptly. Data in the stream after the
+	// fault offset is lost, so a frame can be cut in half.
+	Reset Action = iota
+
+	// Delay holds back the data at the fault offset for a while.
+	Delay
+
+	// Corrupt flips all bits of the byte at the fault offset.
+	Corrupt
+
+	// Stall stops the data stream at the fault offset. Reads and writes
+	// block until the connection is closed or their deadline expires.
+	Stall
+)
+
+// A Fault is a failure injected into a stream of a FaultConn once a given
+// number of bytes has passed.
+type Fault struct {
+	Dir    Direction
+	Offset int64
+	Action Action
+
+	// Duration is how long a Delay action holds back the data.
+	Duration time.Duration
+}
+
+// ResetAfter returns a fault that closes the connection after n bytes have
+// passed in direction dir.
+func ResetAfter(dir Direction, n int64) Fault {
+	return Fault{Dir: dir, Offset: n, Action: Reset}
+}
+
+// DelayAfter returns a fault that delays the stream in direction dir by d
+// after n bytes have passed.
+func DelayAfter(dir Direction, n int64, d time.Duration) Fault {
+	return Fault{Dir: dir, Offset: n, Action: Delay, Duration: d}
+}
+
+// CorruptAt returns a fault that corrupts the byte at offset n of the stream
+// in direction dir.
+func CorruptAt(dir Direction, n int64) Fault {
+	return Fault{Dir: dir, Offset: n, Action: Corrupt}
+}
+
+// StallAfter returns a fault that stops the stream in direction dir after n
+// bytes have passed.
+func StallAfter(dir Direction, n int64) Fault {
+	return Fault{Dir: dir, Offset: n, Action: Stall}
+}
+
+// A FaultConn wraps a net.Conn and injects a scripted plan of faults into its
+// data streams. Fault offsets are relative to the position of the stream at
+// the time the fault is added, so a plan can be extended while the
+// connection is in use.
+type FaultConn struct {
+	net.Conn
+
+	mu        sync.Mutex
+	faults    []Fault
+	offset    [2]int64
+	deadline  [2]time.Time
+	closeOnce sync.Once
+	closeC    chan struct{}
+}
+
+// NewFaultConn returns a FaultConn wrapping c with the given initial faults.
+func NewFaultConn(c net.Conn, faults ...Fault) *FaultConn {
+	fc := &FaultConn{
+		Conn:   c,
+		closeC: make(chan struct{}),
+	}
+	fc.Inject(faults...)
+	return fc
+}
+
+// Inject adds faults to the plan of the connection. Their offsets are counted
+// from the current position of the respective stream.
+func (c *FaultConn) Inject(faults ...Fault) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, f := range faults {
+		f.Offset += c.offset[f.Dir]
+		c.faults = append(c.faults, f)
+	}
+}
+
+// Read implements the net.Conn interface.
+func (c *FaultConn) Read(p []byte) (int, error) {
+	f, ok := c.next(Read, len(p))
+	if !ok {
+		n, err := c.Conn.Read(p)
+		c.advance(Read, n)
+		return n, err
+	}
+
+	// pass the data in front of the fault
+	if before := int(f.Offset - c.position(Read)); before > 0 {
+		n, err := c.Conn.Read(p[:before])
+		c.advance(Read, n)
+		return n, err
+	}
+
+	c.remove(f)
+	switch f.Action {
+	case Reset:
+		c.Close()
+		return 0, ErrInjected
+
+	case Delay:
+		time.Sleep(f.Duration)
+
+	case Stall:
+		return 0, c.stall(Read)
+
+	case Corrupt:
+		n, err := c.Conn.Read(p[:1])
+		if n > 0 {
+			p[0] ^= 0xff
+		}
+		c.advance(Read, n)
+		return n, err
+	}
+
+	return c.Read(p)
+}
+
+// Write implements the net.Conn interface.
+func (c *FaultConn) Write(p []byte) (int, error) {
+	written := 0
+	for {
+		f, ok := c.next(Write, len(p)-written)
+		if !ok {
+			n, err := c.Conn.Write(p[written:])
+			c.advance(Write, n)
+			return written + n, err
+		}
+
+		// pass the data in front of the fault
+		before := int(f.Offset - c.position(Write))
+		n, err := c.Conn.Write(p[written : written+before])
+		c.advance(Write, n)
+		written += n
+		if err != nil {
+			return written, err
+		}
+
+		c.remove(f)
+		switch f.Action {
+		case Reset:
+			c.Close()
+			return written, ErrInjected
+
+		case Delay:
+			time.Sleep(f.Duration)
+
+		case Stall:
+			return written, c.stall(Write)
+
+		case Corrupt:
+			b := []byte{p[written] ^ 0xff}
+			n, err := c.Conn.Write(b)
+			c.advance(Write, n)
+			written += n
+			if err != nil {
+				return written, err
+			}
+		}
+	}
+}
+
+// Close implements the net.Conn interface.
+func (c *FaultConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closeC) })
+	return c.Conn.Close()
+}
+
+// SetDeadline implements the net.Conn interface.
+func (c *FaultConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline[Read], c.deadline[Write] = t, t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline implements the net.Conn interface.
+func (c *FaultConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline[Read] = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline implements the net.Conn interface.
+func (c *FaultConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline[Write] = t
+	c.mu.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// next returns the first pending fault in direction dir that is triggered
+// within the next n bytes of the stream.
+func (c *FaultConn) next(dir Direction, n int) (Fault, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var next Fault
+	found := false
+	for _, f := range c.faults {
+		if f.Dir != dir || f.Offset >= c.offset[dir]+int64(n) {
+			continue
+		}
+
+		if !found || f.Offset < next.Offset {
+			next, found = f, true
+		}
+	}
+	return next, found
+}
+
+func (c *FaultConn) remove(f Fault) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, g := range c.faults {
+		if g == f {
+			c.faults = append(c.faults[:i:i], c.faults[i+1:]...)
+			return
+		}
+	}
+}
+
+func (c *FaultConn) position(dir Direction) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.offset[dir]
+}
+
+func (c *FaultConn) advance(dir Direction, n int) {
+	c.mu.Lock()
+	c.offset[dir] += int64(n)
+	c.mu.Unlock()
+}
+
+// stall blocks until the connection is closed or the deadline of the stream
+// in direction dir expires.
+func (c *FaultConn) stall(dir Direction) error {
+	// a stalled stream stays stalled
+	c.mu.Lock()
+	c.faults = append(c.faults, Fault{Dir: dir, Offset: c.offset[dir], Action: Stall})
+	deadline := c.deadline[dir]
+	c.mu.Unlock()
+
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case <-c.closeC:
+		return net.ErrClosed
+	case <-expired:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// A FaultDialer dials network connections wrapped in FaultConns. The n-th
+// connection dialed gets the n-th plan of faults; connections beyond the
+// given plans are dialed without faults.
+type FaultDialer struct {
+	mu    sync.Mutex
+	plans [][]Fault
+	conns []*FaultConn
+}
+
+// NewFaultDialer returns a FaultDialer using the given plans.
+func NewFaultDialer(plans ...[]Fault) *FaultDialer {
+	return &FaultDialer{plans: plans}
+}
+
+// Dial connects to the address on the named network using net.Dial and wraps
+// the connection in a FaultConn. It is suitable for use as the NetDial
+// function of a stomp.Dialer.
+func (d *FaultDialer) Dial(network, addr string) (net.Conn, error) {
+	nc, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var plan []Fault
+	if len(d.conns) < len(d.plans) {
+		plan = d.plans[len(d.conns)]
+	}
+
+	fc := NewFaultConn(nc, plan...)
+	d.conns = append(d.conns, fc)
+	return fc, nil
+}
+
+// Conns returns all connections dialed so far in the order they were dialed.
+func (d *FaultDialer) Conns() []*FaultConn {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]*FaultConn(nil), d.conns...)
+}
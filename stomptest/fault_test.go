@@ -0,0 +1,41 @@
+package stomptest
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFaultConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	fc := NewFaultConn(client, CorruptAt(Write, 1), ResetAfter(Write, 3))
+	go func() {
+		fc.Write([]byte("abcdef"))
+	}()
+
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	data, err := io.ReadAll(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "a\x9dc"; string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+}
+
+func TestFaultConnStall(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	fc := NewFaultConn(client, StallAfter(Read, 0))
+	fc.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+
+	_, err := fc.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("got error %v, want timeout", err)
+	}
+}
@@ -0,0 +1,376 @@
+// Package stomptest provides an in-memory STOMP 1.2 server for tests.
+//
+// The server listens on the local loopback interface and speaks enough of
+// the protocol to exercise a client end-to-end: CONNECT, SEND, SUBSCRIBE with
+// all three acknowledgment modes, ACK and NACK, receipts, transactions and
+// heart-beating. Errors can be injected to test failure handling.
+//
+// Destinations starting with "/topic/" have publish-subscribe semantics, every
+// subscriber receives a copy of each message and messages sent without any
+// subscriber are discarded. All other destinations are queues, where each
+// message is delivered to exactly one subscriber and kept until a subscriber
+// is available. Topic subscriptions may use the portable wildcards of
+// stomp.Dialect, which are those of RabbitMQ.
+package stomptest
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cumulodev/stomp"
+)
+
+// A Server is a STOMP server listening on a system-chosen port on the local
+// loopback interface, for use in end-to-end tests.
+type Server struct {
+	// Addr is the address the server listens on, in the form host:port.
+	// It is suitable for use with stomp.Dial.
+	Addr string
+
+	// Listener is the network listener accepting client connections.
+	Listener net.Listener
+
+	// SendHeartBeat is the smallest interval the server can guarantee
+	// between outgoing heart-beats. Zero means the server cannot send
+	// heart-beats.
+	SendHeartBeat time.Duration
+
+	// RecvHeartBeat is the desired interval between heart-beats received
+	// from the client. Zero means the server does not want heart-beats.
+	RecvHeartBeat time.Duration
+
+	// Authenticate, if non-nil, is called with the login and passcode
+	// headers of each CONNECT frame. The connection is refused with an
+	// ERROR frame if it returns false.
+	Authenticate func(login, passcode string) bool
+
+	mu       sync.Mutex
+	conns    map[*conn]struct{}
+	queues   map[string]*queue
+	frames   []*stomp.Frame
+	faults   map[string]string
+	changed  chan struct{}
+	sequence int
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+type queue struct {
+	subs     []*subscription
+	pending  []*stomp.Frame
+	position int
+}
+
+// NewServer starts and returns a new Server. The caller should call Close
+// when finished, to shut it down.
+func NewServer() *Server {
+	s := NewUnstartedServer()
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a new Server but doesn't start it. After changing
+// its configuration, the caller should call Start.
+func NewUnstartedServer() *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("stomptest: failed to listen on a port: %v", err))
+	}
+
+	return &Server{
+		Addr:     l.Addr().String(),
+		Listener: l,
+
+		conns:   make(map[*conn]struct{}),
+		queues:  make(map[string]*queue),
+		faults:  make(map[string]string),
+		changed: make(chan struct{}),
+	}
+}
+
+// Start starts accepting client connections.
+func (s *Server) Start() {
+	s.wg.Add(1)
+	go s.serve()
+}
+
+// Close shuts down the server and closes all client connections. It blocks
+// until all connection handlers have returned.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	for c := range s.conns {
+		c.close()
+	}
+	s.mu.Unlock()
+
+	s.Listener.Close()
+	s.wg.Wait()
+}
+
+// Publish delivers a message to the given destination as if it was sent by
+// another client.
+func (s *Server) Publish(destination string, body []byte, header stomp.Header) {
+	f := &stomp.Frame{
+		Command: "SEND",
+		Header:  stomp.Header{"destination": destination},
+		Body:    body,
+	}
+	for key, value := range header {
+		f.Header[key] = value
+	}
+
+	s.mu.Lock()
+	s.send(f)
+	s.mu.Unlock()
+}
+
+// FailNext arranges for the next frame with the given command to be answered
+// with an ERROR frame carrying message. As required by the protocol, the
+// connection is closed after the ERROR frame has been sent.
+func (s *Server) FailNext(command, message string) {
+	s.mu.Lock()
+	s.faults[command] = message
+	s.mu.Unlock()
+}
+
+// SendError sends an ERROR frame with the given message to every connected
+// client and closes their connections afterwards.
+func (s *Server) SendError(message string) {
+	s.mu.Lock()
+	for c := range s.conns {
+		c.fail(message, nil)
+	}
+	s.mu.Unlock()
+}
+
+// DropConnections abruptly closes all client connections without sending
+// an ERROR frame, as if the network failed.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	for c := range s.conns {
+		c.close()
+	}
+	s.mu.Unlock()
+}
+
+// Connections returns the number of currently connected clients.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for c := range s.conns {
+		if c.connected {
+			n++
+		}
+	}
+	return n
+}
+
+// Frames returns all frames received from clients so far, in the order they
+// were received. Heart-beats are not recorded.
+func (s *Server) Frames() []*stomp.Frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	frames := make([]*stomp.Frame, len(s.frames))
+	copy(frames, s.frames)
+	return frames
+}
+
+// WaitFrames blocks until at least n frames with the given command have been
+// received from clients, and returns all of them. An error is returned if
+// the timeout expires first.
+func (s *Server) WaitFrames(command string, n int, timeout time.Duration) ([]*stomp.Frame, error) {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		var frames []*stomp.Frame
+		for _, f := range s.frames {
+			if f.Command == command {
+				frames = append(frames, f)
+			}
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		if len(frames) >= n {
+			return frames, nil
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return frames, fmt.Errorf("stomptest: received %d of %d %s frames before timeout", len(frames), n, command)
+		}
+	}
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		nc, err := s.Listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			nc.Close()
+			return
+		}
+
+		c := newConn(s, nc)
+		s.conns[c] = struct{}{}
+		s.wg.Add(2)
+		s.mu.Unlock()
+
+		go c.readLoop()
+		go c.writeLoop()
+	}
+}
+
+// record stores a received frame and wakes up all waiting callers. The
+// caller must hold s.mu.
+func (s *Server) record(f *stomp.Frame) {
+	s.frames = append(s.frames, f)
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// send routes the SEND frame f to its destination. The caller must hold s.mu.
+func (s *Server) send(f *stomp.Frame) {
+	s.sequence++
+	msg := &stomp.Frame{
+		Command: "MESSAGE",
+		Header:  make(stomp.Header),
+		Body:    f.Body,
+	}
+	for key, value := range f.Header {
+		if key != "receipt" && key != "transaction" {
+			msg.Header[key] = value
+		}
+	}
+	msg.Header["message-id"] = fmt.Sprintf("%d", s.sequence)
+
+	s.enqueue(f.Header["destination"], msg)
+}
+
+// enqueue delivers msg to the subscribers of destination. The caller must
+// hold s.mu.
+func (s *Server) enqueue(destination string, msg *stomp.Frame) {
+	if strings.HasPrefix(destination, "/topic/") {
+		for name, q := range s.queues {
+			if name == destination || stomp.MatchDestination(name, destination) {
+				for _, sub := range q.subs {
+					sub.deliver(msg)
+				}
+			}
+		}
+		return
+	}
+
+	q := s.queue(destination)
+
+	q.pending = append(q.pending, msg)
+	s.flush(q)
+}
+
+// flush delivers pending queue messages round-robin to the subscribers of q.
+// The caller must hold s.mu.
+func (s *Server) flush(q *queue) {
+	for len(q.pending) > 0 && len(q.subs) > 0 {
+		q.position = (q.position + 1) % len(q.subs)
+		q.subs[q.position].deliver(q.pending[0])
+		q.pending = q.pending[1:]
+	}
+}
+
+// requeue puts unacknowledged messages back in front of their queue to get
+// them redelivered. The caller must hold s.mu.
+func (s *Server) requeue(destination string, msgs []*stomp.Frame) {
+	if len(msgs) == 0 || strings.HasPrefix(destination, "/topic/") {
+		return
+	}
+
+	q := s.queue(destination)
+	pending := make([]*stomp.Frame, 0, len(msgs)+len(q.pending))
+	for _, msg := range msgs {
+		redelivered := copyFrame(msg)
+		redelivered.Header["redelivered"] = "true"
+		pending = append(pending, redelivered)
+	}
+	q.pending = append(pending, q.pending...)
+	s.flush(q)
+}
+
+func (s *Server) queue(destination string) *queue {
+	q, ok := s.queues[destination]
+	if !ok {
+		q = &queue{}
+		s.queues[destination] = q
+	}
+	return q
+}
+
+type subscription struct {
+	conn        *conn
+	id          string
+	destination string
+	ack         stomp.AckMode
+	unacked     []*stomp.Frame
+}
+
+// deliver sends a copy of msg to the subscriber. The caller must hold s.mu.
+func (sub *subscription) deliver(msg *stomp.Frame) {
+	f := copyFrame(msg)
+	f.Header["subscription"] = sub.id
+
+	if sub.ack != stomp.AckAuto {
+		sub.conn.srv.sequence++
+		f.Header["ack"] = fmt.Sprintf("ack-%d", sub.conn.srv.sequence)
+		sub.unacked = append(sub.unacked, f)
+	}
+
+	sub.conn.send(f)
+}
+
+// acknowledge removes the messages covered by the given ack identifier from
+// the unacknowledged messages and returns them. The caller must hold s.mu.
+func (sub *subscription) acknowledge(id string) []*stomp.Frame {
+	for i, f := range sub.unacked {
+		if f.Header["ack"] != id {
+			continue
+		}
+
+		var acked []*stomp.Frame
+		if sub.ack == stomp.AckClient {
+			acked = append(acked, sub.unacked[:i+1]...)
+			sub.unacked = append(sub.unacked[:0:0], sub.unacked[i+1:]...)
+		} else {
+			acked = append(acked, f)
+			sub.unacked = append(sub.unacked[:i:i], sub.unacked[i+1:]...)
+		}
+		return acked
+	}
+
+	return nil
+}
+
+func copyFrame(f *stomp.Frame) *stomp.Frame {
+	c := &stomp.Frame{
+		Command: f.Command,
+		Header:  make(stomp.Header, len(f.Header)),
+		Body:    f.Body,
+	}
+	for key, value := range f.Header {
+		c.Header[key] = value
+	}
+	return c
+}
@@ -0,0 +1,220 @@
+package stomptest
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+)
+
+func TestSendReceive(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.Subscribe("/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.Send("/queue/test", "text/plain", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-sub.C:
+		if string(msg.Body) != "hello" {
+			t.Errorf("got body %q, want %q", msg.Body, "hello")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+}
+
+func TestAckClient(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	c := dial(t, s)
+	c.write(t, "SUBSCRIBE", stomp.Header{"id": "0", "destination": "/queue/test", "ack": "client"})
+	for _, body := range []string{"a", "b", "c"} {
+		s.Publish("/queue/test", []byte(body), nil)
+	}
+
+	var msgs []*stomp.Frame
+	for i := 0; i < 3; i++ {
+		msgs = append(msgs, c.read(t, "MESSAGE"))
+	}
+
+	// cumulative acknowledgment of the first two messages
+	c.write(t, "ACK", stomp.Header{"id": msgs[1].Header["ack"], "receipt": "r1"})
+	c.read(t, "RECEIPT")
+	c.nc.Close()
+
+	// only the unacknowledged message is redelivered
+	c = dial(t, s)
+	c.write(t, "SUBSCRIBE", stomp.Header{"id": "0", "destination": "/queue/test", "ack": "client-individual"})
+	msg := c.read(t, "MESSAGE")
+	if string(msg.Body) != "c" || msg.Header["redelivered"] != "true" {
+		t.Errorf("got redelivered message %q (%v), want %q", msg.Body, msg.Header, "c")
+	}
+}
+
+func TestNack(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	c := dial(t, s)
+	c.write(t, "SUBSCRIBE", stomp.Header{"id": "0", "destination": "/queue/test", "ack": "client-individual"})
+	s.Publish("/queue/test", []byte("a"), nil)
+
+	msg := c.read(t, "MESSAGE")
+	c.write(t, "NACK", stomp.Header{"id": msg.Header["ack"]})
+
+	msg = c.read(t, "MESSAGE")
+	if string(msg.Body) != "a" || msg.Header["redelivered"] != "true" {
+		t.Errorf("got message %q (%v), want redelivery of %q", msg.Body, msg.Header, "a")
+	}
+}
+
+func TestTopic(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	c1, c2 := dial(t, s), dial(t, s)
+	c1.write(t, "SUBSCRIBE", stomp.Header{"id": "0", "destination": "/topic/test", "receipt": "r"})
+	c2.write(t, "SUBSCRIBE", stomp.Header{"id": "0", "destination": "/topic/test", "receipt": "r"})
+	c1.read(t, "RECEIPT")
+	c2.read(t, "RECEIPT")
+
+	c1.write(t, "SEND", stomp.Header{"destination": "/topic/test"})
+	c1.read(t, "MESSAGE")
+	c2.read(t, "MESSAGE")
+}
+
+func TestTransaction(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	c := dial(t, s)
+	c.write(t, "SUBSCRIBE", stomp.Header{"id": "0", "destination": "/queue/test"})
+	c.write(t, "BEGIN", stomp.Header{"transaction": "tx1"})
+	c.write(t, "SEND", stomp.Header{"destination": "/queue/test", "transaction": "tx1"}, "aborted")
+	c.write(t, "ABORT", stomp.Header{"transaction": "tx1"})
+	c.write(t, "BEGIN", stomp.Header{"transaction": "tx2"})
+	c.write(t, "SEND", stomp.Header{"destination": "/queue/test", "transaction": "tx2"}, "committed")
+	c.write(t, "COMMIT", stomp.Header{"transaction": "tx2"})
+
+	msg := c.read(t, "MESSAGE")
+	if string(msg.Body) != "committed" {
+		t.Errorf("got message %q, want %q", msg.Body, "committed")
+	}
+
+	c.write(t, "COMMIT", stomp.Header{"transaction": "tx2"})
+	c.read(t, "ERROR")
+}
+
+func TestHeartBeat(t *testing.T) {
+	s := NewUnstartedServer()
+	s.SendHeartBeat = 10 * time.Millisecond
+	s.Start()
+	defer s.Close()
+
+	c := dial(t, s, "0,20")
+	if f := c.read(t, ""); f.Command != "" {
+		t.Errorf("got %s frame, want heart-beat", f.Command)
+	}
+}
+
+func TestFailNext(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.FailNext("CONNECT", "access denied")
+	_, err := stomp.Dial("tcp", s.Addr)
+	if e, ok := err.(*stomp.Error); !ok || e.Error() != "access denied" {
+		t.Fatalf("got error %v, want ERROR frame", err)
+	}
+}
+
+func TestSendError(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	c := dial(t, s)
+	s.SendError("shutting down")
+	if f := c.read(t, "ERROR"); f.Header["message"] != "shutting down" {
+		t.Errorf("got message %q, want %q", f.Header["message"], "shutting down")
+	}
+}
+
+type client struct {
+	nc      net.Conn
+	encoder *stomp.Encoder
+	decoder *stomp.Decoder
+}
+
+// dial opens a raw client connection to s and sends a CONNECT frame with the
+// optional heart-beat header.
+func dial(t *testing.T, s *Server, heartbeat ...string) *client {
+	nc, err := net.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nc.Close() })
+
+	c := &client{
+		nc:      nc,
+		encoder: stomp.NewEncoder(nc),
+		decoder: stomp.NewDecoder(nc),
+	}
+
+	header := stomp.Header{"accept-version": "1.2"}
+	if len(heartbeat) > 0 {
+		header["heart-beat"] = heartbeat[0]
+	}
+	c.write(t, "CONNECT", header)
+	c.read(t, "CONNECTED")
+	return c
+}
+
+func (c *client) write(t *testing.T, command string, header stomp.Header, body ...string) {
+	t.Helper()
+	f := &stomp.Frame{Command: command, Header: header}
+	if len(body) > 0 {
+		f.Body = []byte(body[0])
+	}
+
+	if err := c.encoder.Encode(f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// read returns the next frame skipping heart-beats, unless a heart-beat is
+// expected by passing an empty command.
+func (c *client) read(t *testing.T, command string) *stomp.Frame {
+	t.Helper()
+	c.nc.SetReadDeadline(time.Now().Add(time.Second))
+
+	for {
+		f, err := c.decoder.Decode()
+		if err != nil {
+			t.Fatalf("waiting for %s frame: %v", command, err)
+		}
+
+		if f.Command == "" && command != "" {
+			continue
+		}
+
+		if f.Command != command {
+			t.Fatalf("got %s frame (%v), want %s", f.Command, f.Header, command)
+		}
+		return f
+	}
+}
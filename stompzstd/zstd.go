@@ -0,0 +1,73 @@
+// Package stompzstd provides a Zstandard compressor for message bodies.
+// Importing the package registers it for the "zstd" content encoding:
+//
+//	import _ "github.com/cumulodev/stomp/stompzstd"
+//
+//	err := conn.Send("/queue/docs", "application/json", body, stomp.Compress(stompzstd.Encoding))
+package stompzstd
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/cumulodev/stomp"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encoding is the content encoding of Zstandard compressed bodies.
+const Encoding = "zstd"
+
+func init() {
+	stomp.RegisterCompressor(Compressor{})
+}
+
+// encoder and decoders are safe for concurrent use of EncodeAll and
+// DecodeAll. A decoder is created for each maximum decompressed size.
+var (
+	encoder, _ = zstd.NewWriter(nil)
+	decoders   sync.Map
+)
+
+func decoderFor(max int) (*zstd.Decoder, error) {
+	if d, ok := decoders.Load(max); ok {
+		return d.(*zstd.Decoder), nil
+	}
+
+	d, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(max)))
+	if err != nil {
+		return nil, err
+	}
+	if prev, loaded := decoders.LoadOrStore(max, d); loaded {
+		d.Close()
+		return prev.(*zstd.Decoder), nil
+	}
+	return d, nil
+}
+
+// Compressor compresses bodies with github.com/klauspost/compress/zstd, a
+// pure Go implementation of Zstandard.
+type Compressor struct{}
+
+// Encoding implements the stomp.Compressor interface.
+func (Compressor) Encoding() string {
+	return Encoding
+}
+
+// Compress implements the stomp.Compressor interface.
+func (Compressor) Compress(data []byte) ([]byte, error) {
+	return encoder.EncodeAll(data, nil), nil
+}
+
+// Decompress implements the stomp.Compressor interface.
+func (Compressor) Decompress(data []byte, max int) ([]byte, error) {
+	decoder, err := decoderFor(max)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := decoder.DecodeAll(data, nil)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		return nil, stomp.ErrBodyTooLarge
+	}
+	return body, err
+}
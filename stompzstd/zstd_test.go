@@ -0,0 +1,35 @@
+package stompzstd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cumulodev/stomp"
+)
+
+func TestCompressor(t *testing.T) {
+	data := bytes.Repeat([]byte("hello, world "), 100)
+
+	compressed, err := Compressor{}.Compress(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compressed) >= len(data) {
+		t.Errorf("got %d octets, want less than %d", len(compressed), len(data))
+	}
+
+	got, err := Compressor{}.Decompress(compressed, len(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("round trip changed data")
+	}
+
+	if _, err := (Compressor{}).Decompress(compressed, len(data)-1); err != stomp.ErrBodyTooLarge {
+		t.Errorf("got error %v for oversized body, want %v", err, stomp.ErrBodyTooLarge)
+	}
+	if _, err := (Compressor{}).Decompress([]byte("garbage"), len(data)); err == nil {
+		t.Error("decompressed garbage")
+	}
+}
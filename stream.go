@@ -0,0 +1,176 @@
+package stomp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SendStream sends a message like Send, but reads the body from r. If size
+// is not negative, exactly size octets are copied from r to the network
+// connection without buffering the body in memory. As the frame cannot be
+// completed if r fails or ends early, the connection is reset in that case.
+// If size is negative, r is read to the end before the message is sent.
+//
+// Options and outbound interceptors see a frame without body. They must not
+// set a body, so body transformations such as Compress have no effect.
+//
+// If size is not negative, the message is not stored in the outbox of the
+// connection. It is sent directly and fails if the connection is down.
+func (c *Conn) SendStream(destination, contentType string, r io.Reader, size int64, options ...Option) error {
+	if size < 0 {
+		body, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return c.Send(destination, contentType, body, options...)
+	}
+
+	frame := &Frame{
+		Command: "SEND",
+		Header: Header{
+			"destination":    destination,
+			"content-type":   contentType,
+			"content-length": strconv.FormatInt(size, 10),
+		},
+		source:     r,
+		sourceSize: size,
+	}
+
+	return c.safeWrite(frame, options...)
+}
+
+// writeStream writes a frame with its body read from f.source. This
+// function is not thread safe!
+func (c *Conn) writeStream(f *Frame) error {
+	head := encodeHead(f)
+	size := int64(head.Len()) + f.sourceSize + 2
+
+	body := io.MultiReader(head, io.LimitReader(f.source, f.sourceSize), strings.NewReader("\x00\n"))
+	n, err := io.Copy(&deadlineWriter{conn: c.conn, timeout: c.heartbeat.writeTimeout()}, body)
+	if err == nil && n < size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil {
+		c.metrics.FrameSent(f.Command, int(n))
+	}
+	return err
+}
+
+// deadlineWriter extends the write deadline of a connection before each
+// write, so large bodies are not limited by a single deadline.
+type deadlineWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	if w.timeout > 0 {
+		w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	}
+	return w.conn.Write(p)
+}
+
+// BodyReader returns a reader for the body of the message. For streamed
+// messages, see Dialer.StreamThreshold, the Body field is nil and the body
+// is read from the network connection. No other frames are received until
+// the reader has been read to the end or closed, which discards the rest of
+// the body. For other messages, the reader reads from Body.
+func (m *Message) BodyReader() io.ReadCloser {
+	if m.stream != nil {
+		return m.stream
+	}
+	return io.NopCloser(bytes.NewReader(m.Body))
+}
+
+// errBodyClosed is returned when reading a streamed body after Close.
+var errBodyClosed = errors.New("stomp: read on closed message body")
+
+// bodyStream reads a message body of known length from a decoder.
+type bodyStream struct {
+	d         *Decoder
+	remaining int64
+
+	// refresh extends the read deadline of the connection, if set.
+	refresh func()
+
+	once   sync.Once
+	done   chan struct{}
+	err    error
+	closed bool
+}
+
+func newBodyStream(d *Decoder, length int64) *bodyStream {
+	return &bodyStream{d: d, remaining: length, done: make(chan struct{})}
+}
+
+func (s *bodyStream) Read(p []byte) (int, error) {
+	if s.closed {
+		return 0, errBodyClosed
+	}
+
+	select {
+	case <-s.done:
+		if s.err != nil {
+			return 0, s.err
+		}
+		return 0, io.EOF
+	default:
+	}
+
+	if s.refresh != nil {
+		s.refresh()
+	}
+
+	if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n, err := s.d.reader.Read(p)
+	s.d.n += int64(n)
+	s.remaining -= int64(n)
+
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		s.finish(err)
+		return n, err
+	}
+
+	if s.remaining == 0 {
+		// the body is complete, consume the terminating NULL octet
+		s.finish(s.d.readNull())
+	}
+	return n, nil
+}
+
+// Close discards the rest of the body.
+func (s *bodyStream) Close() error {
+	if s.closed {
+		return nil
+	}
+
+	_, err := io.Copy(io.Discard, struct{ io.Reader }{s})
+	s.closed = true
+	return err
+}
+
+// finish records the result of reading the body and releases the decoder.
+func (s *bodyStream) finish(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+// discard discards the body of f if it is streamed.
+func (f *Frame) discard() {
+	if f.stream != nil {
+		f.stream.Close()
+	}
+}
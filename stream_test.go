@@ -0,0 +1,89 @@
+package stomp_test
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"github.com/cumulodev/stomp/stomptest"
+)
+
+func TestStream(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	d := &stomp.Dialer{StreamThreshold: 1000}
+	conn, err := d.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.Subscribe("/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(large)
+	if err := conn.SendStream("/queue/test", "application/octet-stream", bytes.NewReader(large), int64(len(large))); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SendStream("/queue/test", "text/plain", bytes.NewReader(large[:10]), -1); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err := conn.SendStream("/queue/test", "application/octet-stream", bytes.NewReader(large), 2000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := conn.Send("/queue/test", "text/plain", []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+
+	frames, err := s.WaitFrames("SEND", 5, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := frames[0]; !bytes.Equal(f.Body, large) || f.Header["content-length"] != strconv.Itoa(len(large)) {
+		t.Errorf("got SEND frame %v with %d octets, want streamed body", f.Header, len(f.Body))
+	}
+
+	// large bodies are streamed
+	msg := receive(t, sub)
+	if msg.Body != nil || msg.ContentLength() != len(large) {
+		t.Errorf("got message %v with %d octets, want streamed body", msg.Header, len(msg.Body))
+	}
+	body, err := io.ReadAll(msg.BodyReader())
+	if err != nil || !bytes.Equal(body, large) {
+		t.Errorf("got %d octets, %v, want streamed body", len(body), err)
+	}
+
+	// small bodies are buffered
+	if msg := receive(t, sub); !bytes.Equal(msg.Body, large[:10]) {
+		t.Errorf("got message %q, want buffered body", msg.Body)
+	}
+
+	// closing discards the rest of the body
+	msg = receive(t, sub)
+	r := msg.BodyReader()
+	if _, err := io.ReadFull(r, make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var v []byte
+	if err := receive(t, sub).Decode(&v); err != nil || !bytes.Equal(v, large[:2000]) {
+		t.Errorf("got %d octets, %v, want decoded streamed body", len(v), err)
+	}
+
+	if msg := receive(t, sub); string(msg.Body) != "small" {
+		t.Errorf("got message %q, want %q", msg.Body, "small")
+	}
+}
@@ -0,0 +1,181 @@
+package stomp_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"github.com/cumulodev/stomp/stomptest"
+)
+
+func TestSubscriptionUnsubscribe(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.Subscribe("/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.ID() == "" {
+		t.Error("got empty subscription id")
+	}
+	if sub.Destination != "/queue/test" {
+		t.Errorf("got destination %q, want %q", sub.Destination, "/queue/test")
+	}
+	if err := sub.Err(); err != nil {
+		t.Errorf("got error %v for active subscription", err)
+	}
+
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-sub.Done():
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for subscription to end")
+	}
+	if _, ok := <-sub.C; ok {
+		t.Error("got message after unsubscribe")
+	}
+	if err := sub.Err(); !errors.Is(err, stomp.ErrUnsubscribed) {
+		t.Errorf("got error %v, want %v", err, stomp.ErrUnsubscribed)
+	}
+
+	// unsubscribing again does not send another frame
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WaitFrames("UNSUBSCRIBE", 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if n := commands(s.Frames(), "UNSUBSCRIBE"); n != 1 {
+		t.Errorf("got %d UNSUBSCRIBE frames, want 1", n)
+	}
+}
+
+func TestSubscriptionClose(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := conn.Subscribe("/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	select {
+	case <-sub.Done():
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for subscription to end")
+	}
+	if _, ok := <-sub.C; ok {
+		t.Error("got message after close")
+	}
+	if err := sub.Err(); !errors.Is(err, stomp.ErrClosed) {
+		t.Errorf("got error %v, want %v", err, stomp.ErrClosed)
+	}
+	if err := sub.Unsubscribe(); err != nil {
+		t.Errorf("got error %v unsubscribing ended subscription", err)
+	}
+}
+
+func TestMessageAck(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := conn.Subscribe("/queue/test", stomp.Ack(stomp.AckIndividual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Publish("/queue/test", []byte("a"), nil)
+	s.Publish("/queue/test", []byte("b"), nil)
+
+	msg := receive(t, sub)
+	if err := msg.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	frames, err := s.WaitFrames("ACK", 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := frames[0].Header["id"]; id != msg.AckID() {
+		t.Errorf("got ACK for %q, want %q", id, msg.AckID())
+	}
+
+	if err := receive(t, sub).Nack(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WaitFrames("NACK", 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// a message not received from a connection cannot be acknowledged
+	if err := (&stomp.Message{}).Ack(); err == nil {
+		t.Error("got no error acknowledging message without connection")
+	}
+}
+
+func TestSubscriptionUnsubscribeFull(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for range 50 {
+		s.Publish("/queue/test", []byte("hello"), nil)
+	}
+	sub, err := conn.Subscribe("/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the connection is blocked on the full buffer of the subscription
+	receive(t, sub)
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sub.Unsubscribe()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for Unsubscribe")
+	}
+	if err := sub.Err(); !errors.Is(err, stomp.ErrUnsubscribed) {
+		t.Errorf("got error %v, want %v", err, stomp.ErrUnsubscribed)
+	}
+
+	// the connection is usable again
+	other, err := conn.Subscribe("/queue/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Publish("/queue/other", []byte("hello"), nil)
+	receive(t, other)
+}
@@ -0,0 +1,132 @@
+package stomp
+
+import "reflect"
+
+// An Envelope is a message received from a TypedSubscription. Err is set if
+// the message body could not be decoded into Value.
+type Envelope[T any] struct {
+	Value   T
+	Message *Message
+	Err     error
+}
+
+// A TypedSubscription is a subscription delivering message bodies decoded
+// into values of type T.
+type TypedSubscription[T any] struct {
+	// C is the channel where decoded messages are sent to. It is closed
+	// when the underlying subscription is closed.
+	C <-chan Envelope[T]
+
+	sub *Subscription
+}
+
+// Subscription returns the underlying subscription, e.g. to unsubscribe.
+func (s *TypedSubscription[T]) Subscription() *Subscription {
+	return s.sub
+}
+
+// Typed configures how typed subscriptions handle messages whose body
+// cannot be decoded. The zero value delivers them with the Err field of the
+// Envelope set.
+type Typed[T any] struct {
+	// DeadLetter, if not empty, is the destination messages that cannot be
+	// decoded are forwarded to instead of being delivered. The forwarded
+	// message carries the original destination, message id and the decode
+	// error in headers. The original message is acknowledged once it was
+	// forwarded if the subscription is in the client-individual ack mode.
+	// In the client ack mode, an ACK would cover the messages received
+	// before, so acknowledging it is left to the caller.
+	DeadLetter string
+
+	// OnError, if non-nil, is called with each message that cannot be
+	// decoded, after it was forwarded to the DeadLetter destination. The
+	// message is not delivered. If there is no DeadLetter destination,
+	// acknowledging the message is left to OnError.
+	OnError func(msg *Message, err error)
+}
+
+// SubscribeTyped subscribes to a destination like Conn.Subscribe and decodes
+// the bodies of received messages into values of type T using the codec for
+// their content type, see Message.Decode. Messages that cannot be decoded
+// are delivered with the Err field of the Envelope set; use Typed to route
+// them elsewhere.
+func SubscribeTyped[T any](c *Conn, destination string, options ...Option) (*TypedSubscription[T], error) {
+	return Typed[T]{}.Subscribe(c, destination, options...)
+}
+
+// Subscribe subscribes to a destination like SubscribeTyped and handles
+// messages that cannot be decoded as configured by t.
+func (t Typed[T]) Subscribe(c *Conn, destination string, options ...Option) (*TypedSubscription[T], error) {
+	sub, err := c.Subscribe(destination, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Envelope[T])
+	go t.decode(c, sub, ch)
+	return &TypedSubscription[T]{C: ch, sub: sub}, nil
+}
+
+func (t Typed[T]) decode(c *Conn, sub *Subscription, ch chan<- Envelope[T]) {
+	defer close(ch)
+
+	for msg := range sub.C {
+		v, err := decodeValue[T](msg)
+		if err != nil && t.reject(c, msg, err) {
+			continue
+		}
+
+		select {
+		case ch <- Envelope[T]{Value: v, Message: msg, Err: err}:
+		case <-sub.Done():
+			// nobody is receiving from an ended subscription
+			return
+		}
+	}
+}
+
+// decodeValue decodes the body of msg into a new value of type T. If T is a
+// pointer type, such as a generated protobuf message, the value it points to
+// is allocated and the body decoded into it.
+func decodeValue[T any](msg *Message) (T, error) {
+	var v T
+	if typ := reflect.TypeFor[T](); typ.Kind() == reflect.Pointer {
+		v = reflect.New(typ.Elem()).Interface().(T)
+		return v, msg.Decode(v)
+	}
+	return v, msg.Decode(&v)
+}
+
+// reject routes a message that cannot be decoded and reports whether it was
+// handled.
+func (t Typed[T]) reject(c *Conn, msg *Message, err error) bool {
+	if t.DeadLetter != "" {
+		if c.deadLetter(t.DeadLetter, msg, err) != nil {
+			// deliver the message rather than losing it
+			return false
+		}
+	}
+
+	if t.OnError != nil {
+		t.OnError(msg, err)
+	}
+	return t.DeadLetter != "" || t.OnError != nil
+}
+
+// deadLetter forwards msg to destination and acknowledges it if it can be
+// acknowledged on its own.
+func (c *Conn) deadLetter(destination string, msg *Message, cause error) error {
+	err := c.Send(destination, msg.ContentType(), msg.Body, func(f *Frame) {
+		f.Header["original-destination"] = msg.Destination()
+		f.Header["original-message-id"] = msg.Id()
+		f.Header["decode-error"] = cause.Error()
+	})
+	if err != nil {
+		return err
+	}
+
+	if msg.mode != AckIndividual {
+		return nil
+	}
+	return c.Ack(msg)
+}
@@ -0,0 +1,197 @@
+package stomp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"github.com/cumulodev/stomp/stomptest"
+)
+
+type order struct {
+	ID int `json:"id"`
+}
+
+func receiveEnvelope[T any](t *testing.T, sub *stomp.TypedSubscription[T]) stomp.Envelope[T] {
+	t.Helper()
+	select {
+	case env, ok := <-sub.C:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return env
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for message")
+		return stomp.Envelope[T]{}
+	}
+}
+
+func TestSubscribeTyped(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := stomp.SubscribeTyped[order](conn, "/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SendValue("/queue/test", order{ID: 1})
+	conn.Send("/queue/test", "application/json", []byte("not json"))
+
+	if env := receiveEnvelope(t, sub); env.Err != nil || env.Value.ID != 1 {
+		t.Errorf("got envelope %+v, want order 1", env)
+	}
+	if env := receiveEnvelope(t, sub); env.Err == nil || string(env.Message.Body) != "not json" {
+		t.Errorf("got envelope %+v, want decode error", env)
+	}
+
+	if err := conn.Unsubscribe(sub.Subscription()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubscribeTypedDeadLetter(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	dlq, err := conn.Subscribe("/queue/test.dlq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 1)
+	sub, err := stomp.Typed[order]{
+		DeadLetter: "/queue/test.dlq",
+		OnError:    func(msg *stomp.Message, err error) { errs <- err },
+	}.Subscribe(conn, "/queue/test", stomp.Ack(stomp.AckIndividual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Send("/queue/test", "application/json", []byte("not json"))
+	conn.SendValue("/queue/test", order{ID: 2})
+
+	// only decodable messages are delivered
+	if env := receiveEnvelope(t, sub); env.Err != nil || env.Value.ID != 2 {
+		t.Errorf("got envelope %+v, want order 2", env)
+	}
+
+	msg := receive(t, dlq)
+	if string(msg.Body) != "not json" || msg.Header["original-destination"] != "/queue/test" || msg.Header["decode-error"] == "" {
+		t.Errorf("got dead letter %v %q", msg.Header, msg.Body)
+	}
+
+	select {
+	case <-errs:
+	case <-time.After(time.Second):
+		t.Error("error handler not called")
+	}
+
+	// the rejected message was acknowledged
+	if _, err := s.WaitFrames("ACK", 1, time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSubscribeTypedDeadLetterClientAck(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	dlq, err := conn.Subscribe("/queue/test.dlq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 1)
+	sub, err := stomp.Typed[order]{
+		DeadLetter: "/queue/test.dlq",
+		OnError:    func(msg *stomp.Message, err error) { errs <- err },
+	}.Subscribe(conn, "/queue/test", stomp.Ack(stomp.AckClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SendValue("/queue/test", order{ID: 1})
+	conn.Send("/queue/test", "application/json", []byte("not json"))
+	env := receiveEnvelope(t, sub)
+	if env.Err != nil || env.Value.ID != 1 {
+		t.Errorf("got envelope %+v, want order 1", env)
+	}
+	receive(t, dlq)
+	select {
+	case <-errs:
+	case <-time.After(time.Second):
+		t.Fatal("error handler not called")
+	}
+
+	// a cumulative ACK of the rejected message would acknowledge the
+	// order as well, so only the order is acknowledged
+	if err := env.Message.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WaitFrames("ACK", 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range s.Frames() {
+		if f.Command == "ACK" && f.Header["id"] != env.Message.AckID() {
+			t.Errorf("got ACK for %q, want %q", f.Header["id"], env.Message.AckID())
+		}
+	}
+}
+
+func TestSubscribeTypedUnsubscribe(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := stomp.SubscribeTyped[order](conn, "/queue/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		conn.SendValue("/queue/test", order{ID: i})
+	}
+	receiveEnvelope(t, sub)
+
+	// the channel is closed although decoded messages were not received
+	if err := sub.Subscription().Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(time.Second)
+	for n := 0; ; n++ {
+		select {
+		case _, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if n > 0 {
+				t.Fatal("got several messages after unsubscribe")
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting for channel to close")
+		}
+	}
+}
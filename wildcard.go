@@ -0,0 +1,172 @@
+package stomp
+
+import (
+	"errors"
+	"strings"
+)
+
+// A Dialect translates portable destination patterns to the wildcard syntax
+// of a broker.
+//
+// The name of a destination, following its last slash, consists of segments
+// separated by dots. In a portable pattern, a "*" segment matches exactly one
+// segment and a "#" segment matches any number of segments, e.g.
+// "/topic/orders.*.created" or "/topic/orders.#".
+type Dialect struct {
+	// Any is the wildcard matching exactly one segment.
+	Any string
+
+	// Rest is the wildcard matching any number of segments.
+	Rest string
+
+	// Trailing reports whether Rest may only be used as the last segment.
+	Trailing bool
+
+	// Composite separates the destinations of a composite destination,
+	// which subscribes to all of them at once. Empty if the broker does not
+	// support composite destinations.
+	Composite string
+}
+
+var (
+	// ActiveMQ is the dialect of Apache ActiveMQ.
+	ActiveMQ = Dialect{Any: "*", Rest: ">", Trailing: true, Composite: ","}
+
+	// RabbitMQ is the dialect of the STOMP plugin of RabbitMQ for
+	// destinations bound to topic exchanges.
+	RabbitMQ = Dialect{Any: "*", Rest: "#"}
+)
+
+// Destination translates the given patterns to a destination of the broker.
+// Multiple patterns are combined to a composite destination.
+func (d Dialect) Destination(patterns ...string) (string, error) {
+	if len(patterns) == 0 {
+		return "", errors.New("stomp: no destination pattern")
+	}
+	if len(patterns) > 1 && d.Composite == "" {
+		return "", errors.New("stomp: composite destinations are not supported")
+	}
+
+	translated := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		if d.Composite != "" && strings.Contains(pattern, d.Composite) {
+			return "", errors.New("stomp: destination pattern contains composite separator")
+		}
+
+		prefix, segments := splitDestination(pattern)
+		for j, segment := range segments {
+			switch segment {
+			case "*":
+				segments[j] = d.Any
+			case "#":
+				if d.Trailing && j != len(segments)-1 {
+					return "", errors.New("stomp: wildcard # must be the last segment")
+				}
+				segments[j] = d.Rest
+			}
+		}
+		translated[i] = prefix + strings.Join(segments, ".")
+	}
+
+	return strings.Join(translated, d.Composite), nil
+}
+
+// Subscribe subscribes to the destinations matching pattern, see
+// Conn.Subscribe. Messages carry the destination they were sent to.
+func (d Dialect) Subscribe(c *Conn, pattern string, options ...Option) (*Subscription, error) {
+	return d.SubscribeAll(c, []string{pattern}, options...)
+}
+
+// SubscribeAll subscribes to the destinations matching any of patterns with
+// a single composite destination.
+func (d Dialect) SubscribeAll(c *Conn, patterns []string, options ...Option) (*Subscription, error) {
+	destination, err := d.Destination(patterns...)
+	if err != nil {
+		return nil, err
+	}
+	return c.Subscribe(destination, options...)
+}
+
+// MatchDestination reports whether destination matches the portable pattern,
+// see Dialect.
+func MatchDestination(pattern, destination string) bool {
+	pp, ps := splitDestination(pattern)
+	dp, ds := splitDestination(destination)
+	return pp == dp && matchSegments(ps, ds)
+}
+
+// splitDestination splits a destination into the prefix up to its last slash
+// and the segments of its name.
+func splitDestination(destination string) (string, []string) {
+	i := strings.LastIndexByte(destination, '/') + 1
+	return destination[:i], strings.Split(destination[i:], ".")
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for i, p := range pattern {
+		switch {
+		case p == "#":
+			for j := i; j <= len(segments); j++ {
+				if matchSegments(pattern[i+1:], segments[j:]) {
+					return true
+				}
+			}
+			return false
+		case i >= len(segments):
+			return false
+		case p != "*" && p != segments[i]:
+			return false
+		}
+	}
+	return len(pattern) == len(segments)
+}
+
+// A Router processes the messages of a subscription, usually one with a
+// wildcard or composite destination, with handlers chosen by the destination
+// of each message. Routes are added with Handle before the router is used.
+//
+// As with a Dispatcher, a message is acknowledged if its handler returns nil
+// and NACKed otherwise.
+type Router struct {
+	// NotFound handles messages not matching any route. If nil, these
+	// messages are acknowledged and dropped.
+	NotFound func(msg *Message) error
+
+	routes []route
+}
+
+type route struct {
+	pattern string
+	handler func(msg *Message) error
+}
+
+// Handle routes the messages with a destination matching the portable
+// pattern to handler. If several patterns match, the route added first is
+// used.
+func (r *Router) Handle(pattern string, handler func(msg *Message) error) {
+	r.routes = append(r.routes, route{pattern: pattern, handler: handler})
+}
+
+// Route processes msg with the handler of its destination. It can be used as
+// the Handler of a Dispatcher.
+func (r *Router) Route(msg *Message) error {
+	destination := msg.Destination()
+	for _, route := range r.routes {
+		if MatchDestination(route.pattern, destination) {
+			return route.handler(msg)
+		}
+	}
+
+	if r.NotFound != nil {
+		return r.NotFound(msg)
+	}
+	return nil
+}
+
+// Run processes the messages received from sub, which must be a
+// subscription of c, one at a time until its channel is closed.
+func (r *Router) Run(c *Conn, sub *Subscription) {
+	for msg := range sub.C {
+		c.settle(msg, r.Route(msg))
+	}
+}
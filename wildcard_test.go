@@ -0,0 +1,134 @@
+package stomp_test
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cumulodev/stomp"
+	"github.com/cumulodev/stomp/stomptest"
+)
+
+func TestMatchDestination(t *testing.T) {
+	tests := []struct {
+		pattern, destination string
+		match                bool
+	}{
+		{"/topic/orders", "/topic/orders", true},
+		{"/topic/orders", "/topic/invoices", false},
+		{"/topic/orders.*", "/topic/orders.eu", true},
+		{"/topic/orders.*", "/topic/orders", false},
+		{"/topic/orders.*", "/topic/orders.eu.created", false},
+		{"/topic/orders.*.created", "/topic/orders.eu.created", true},
+		{"/topic/orders.#", "/topic/orders", true},
+		{"/topic/orders.#", "/topic/orders.eu.created", true},
+		{"/topic/#.created", "/topic/orders.eu.created", true},
+		{"/topic/#.created", "/topic/orders.eu.deleted", false},
+		{"/topic/*", "/queue/orders", false},
+		{"/topic/#", "/topic/a/b", false},
+	}
+	for _, test := range tests {
+		if got := stomp.MatchDestination(test.pattern, test.destination); got != test.match {
+			t.Errorf("MatchDestination(%q, %q) = %v, want %v", test.pattern, test.destination, got, test.match)
+		}
+	}
+}
+
+func TestDialectDestination(t *testing.T) {
+	tests := []struct {
+		dialect  stomp.Dialect
+		patterns []string
+		want     string
+		err      bool
+	}{
+		{stomp.ActiveMQ, []string{"/topic/orders.*.created"}, "/topic/orders.*.created", false},
+		{stomp.ActiveMQ, []string{"/topic/orders.#"}, "/topic/orders.>", false},
+		{stomp.ActiveMQ, []string{"/topic/#.created"}, "", true},
+		{stomp.ActiveMQ, []string{"/topic/orders.#", "/queue/audit"}, "/topic/orders.>,/queue/audit", false},
+		{stomp.RabbitMQ, []string{"/topic/#.created"}, "/topic/#.created", false},
+		{stomp.RabbitMQ, []string{"/topic/orders", "/topic/invoices"}, "", true},
+		{stomp.RabbitMQ, nil, "", true},
+	}
+	for _, test := range tests {
+		got, err := test.dialect.Destination(test.patterns...)
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("Destination(%q) = %q, %v, want %q", test.patterns, got, err, test.want)
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	s := stomptest.NewServer()
+	defer s.Close()
+
+	conn, err := stomp.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sub, err := stomp.RabbitMQ.Subscribe(conn, "/topic/orders.#", stomp.Ack(stomp.AckIndividual))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu      sync.Mutex
+		created []string
+		other   []string
+	)
+	record := func(list *[]string) func(msg *stomp.Message) error {
+		return func(msg *stomp.Message) error {
+			mu.Lock()
+			*list = append(*list, msg.Destination())
+			mu.Unlock()
+			return nil
+		}
+	}
+
+	var r stomp.Router
+	r.Handle("/topic/orders.*.created", record(&created))
+	r.Handle("/topic/orders.*.failed", func(msg *stomp.Message) error {
+		return errors.New("failed")
+	})
+	r.NotFound = record(&other)
+
+	done := make(chan struct{})
+	go func() {
+		r.Run(conn, sub)
+		close(done)
+	}()
+
+	// messages sent to a topic before the subscription are discarded
+	if _, err := s.WaitFrames("SUBSCRIBE", 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	s.Publish("/topic/orders.eu.created", []byte("a"), nil)
+	s.Publish("/topic/orders.eu.deleted", []byte("b"), nil)
+	s.Publish("/topic/orders.us.created", []byte("c"), nil)
+	s.Publish("/topic/invoices.eu.created", []byte("d"), nil)
+	s.Publish("/topic/orders.us.failed", []byte("e"), nil)
+
+	if _, err := s.WaitFrames("ACK", 3, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WaitFrames("NACK", 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"/topic/orders.eu.created", "/topic/orders.us.created"}; !slices.Equal(created, want) {
+		t.Errorf("got created %q, want %q", created, want)
+	}
+	if want := []string{"/topic/orders.eu.deleted"}; !slices.Equal(other, want) {
+		t.Errorf("got other %q, want %q", other, want)
+	}
+}
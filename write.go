@@ -2,48 +2,130 @@ package stomp
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
+	"io"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// An Encoder writes STOMP frames to an output stream.
+type Encoder struct {
+	writer io.Writer
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{writer: w}
+}
+
+// Encode writes the STOMP encoding of f to the stream. A frame without a
+// command is written as a heart-beat.
+func (e *Encoder) Encode(f *Frame) error {
+	_, err := e.writer.Write(encodeFrame(f))
+	return err
+}
+
 type frame struct {
 	body    *Frame
 	options []Option
 	ch      chan error
+
+	// batch, if not nil, are frames written with a single write instead
+	// of body, see SendBatch.
+	batch []*Frame
 }
 
 func (c *Conn) safeWrite(f *Frame, options ...Option) error {
-	ch := make(chan error)
+	ch := make(chan error, 1)
 	frame := frame{
 		body:    f,
 		options: options,
 		ch:      ch,
 	}
 
-	select {
-	case <-c.closeC:
-		return errors.New("connection closed")
+	if f.Command == "SEND" {
+		c.stampMessageID(f)
+	}
 
-	case c.writeC <- frame:
-		return <-ch
+	if c.buffer != nil && f.Command == "SEND" {
+		if ok, err := c.bufferWrite(frame); ok {
+			return err
+		}
 	}
+
+	return c.submit(frame, f.Command)
 }
 
-// unsafeWrite writes the next frame. This function is not thread safe!
-func (c *Conn) unsafeWrite(f *Frame, options ...Option) error {
-	if c.whb > 0 {
-		c.conn.SetWriteDeadline(time.Now().Add(2 * c.whb))
-	} else {
-		c.conn.SetWriteDeadline(time.Time{})
+// submit hands frame to the write loop and waits for the result.
+func (c *Conn) submit(frame frame, command string) error {
+	for {
+		c.mu.Lock()
+		closeC, writeC := c.closeC, c.writeC
+		c.mu.Unlock()
+
+		start := time.Now()
+		select {
+		case <-closeC:
+			if c.buffer == nil || command != "SEND" || frame.batch != nil {
+				return ErrClosed
+			}
+
+			// the connection failed before the frame was taken by the
+			// write loop, buffer it while reconnecting
+			if ok, err := c.bufferWrite(frame); ok {
+				return err
+			}
+			if c.isClosed() {
+				return ErrClosed
+			}
+			// reconnected in the meantime, try the new write loop
+
+		case writeC <- frame:
+			err := <-frame.ch
+			if err == nil {
+				c.metrics.SendLatency(command, time.Since(start))
+			}
+			return err
+		}
+	}
+}
+
+// unsafeWrite writes the next frame, which must already be passed through
+// outbound. This function is not thread safe!
+func (c *Conn) unsafeWrite(f *Frame) error {
+	c.setWriteDeadline()
+
+	if id, ok := f.Header["receipt"]; ok {
+		c.receipts.sent(id)
+	}
+	if f.source != nil {
+		return c.writeStream(f)
 	}
 
-	_, err := c.conn.Write(encodeFrame(f, options...))
+	data := encodeFrame(f)
+	_, err := c.conn.Write(data)
+	if err == nil {
+		c.metrics.FrameSent(f.Command, len(data))
+	}
 	return err
 }
 
-func encodeHeader(key, value string) string {
+// setWriteDeadline sets the deadline for the next write to the network
+// connection. This function is not thread safe!
+func (c *Conn) setWriteDeadline() {
+	if timeout := c.heartbeat.writeTimeout(); timeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	} else {
+		c.conn.SetWriteDeadline(time.Time{})
+	}
+}
+
+func encodeHeader(key, value string, escaped bool) string {
+	if !escaped {
+		return key + ":" + value
+	}
+
 	encode := func(v string) string {
 		v = strings.Replace(v, "\\", "\\\\", -1)
 		v = strings.Replace(v, "\r", "\\r", -1)
@@ -60,22 +142,43 @@ func encodeFrame(f *Frame, options ...Option) []byte {
 		fn(f)
 	}
 
+	if f.Command == "" {
+		// empty frame, send heartbeat
+		return heartbeat
+	}
+
+	buf := encodeHead(f)
+
+	// encode body
+	buf.Write(f.Body)
+	// terminate frame
+	buf.WriteString("\x00\n")
+
+	return buf.Bytes()
+}
+
+// encodeHead encodes the command and header of f up to the start of the
+// body.
+func encodeHead(f *Frame) *bytes.Buffer {
 	// encode command
-	buf := bytes.Buffer{}
+	buf := &bytes.Buffer{}
 	buf.WriteString(f.Command)
 	buf.WriteString("\n")
 
-	// encode header
+	// encode header, CONNECT and CONNECTED frames do not escape header
+	// values for backward compatibility with STOMP 1.0
+	escaped := f.Command != "CONNECT" && f.Command != "CONNECTED" && f.Command != "STOMP"
 	for key, value := range f.Header {
-		buf.WriteString(encodeHeader(key, value))
+		buf.WriteString(encodeHeader(key, value, escaped))
 		buf.WriteString("\n")
 	}
-	buf.WriteString("\n")
 
-	// encode body
-	buf.Write(f.Body)
-	// terminate frame
-	buf.WriteString("\x00\n")
+	if _, ok := f.Header["content-length"]; !ok && bytes.IndexByte(f.Body, 0) >= 0 {
+		// the body cannot be delimited by the NULL octet alone
+		buf.WriteString(encodeHeader("content-length", strconv.Itoa(len(f.Body)), false))
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
 
-	return buf.Bytes()
+	return buf
 }
@@ -0,0 +1,51 @@
+package stomp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func FuzzRoundTrip(f *testing.F) {
+	f.Add("SEND", "destination", "/queue/a:b", []byte("hello"))
+	f.Add("MESSAGE", "key\\with\nnewline", "value\r\n", []byte("a\x00b"))
+	f.Add("ACK", "", "", []byte{})
+
+	f.Fuzz(func(t *testing.T, command, key, value string, body []byte) {
+		if command == "" || strings.ContainsAny(command, "\r\n\x00") {
+			t.Skip("invalid command")
+		}
+
+		frame := &Frame{
+			Command: command,
+			Header:  Header{key: value},
+			Body:    body,
+		}
+		if !encodable(frame) {
+			t.Skip("header not encodable")
+		}
+
+		var buf bytes.Buffer
+		if err := NewEncoder(&buf).Encode(frame); err != nil {
+			t.Fatal(err)
+		}
+
+		// frames not meant for the wire only pass a lenient decoder
+		d := NewDecoder(&buf)
+		d.Strict = checkHeader(command, frame.Header) == nil
+		decoded, err := d.Decode()
+		if err != nil {
+			t.Fatalf("decoding %q: %v", buf.Bytes(), err)
+		}
+
+		if key == "content-length" {
+			// a user supplied content-length is only honored if valid
+			return
+		}
+
+		delete(decoded.Header, "content-length")
+		if !equalFrames(frame, decoded) {
+			t.Fatalf("round trip changed frame %+v to %+v", frame, decoded)
+		}
+	})
+}